@@ -0,0 +1,212 @@
+package cmd
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"fmt"
+	. "grapnel/flag"
+	. "grapnel/lib"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+var flagOlderThan string
+
+// Formats a byte count for people to read.
+func formatSize(size int64) string {
+	value := float64(size)
+	for _, unit := range []string{"B", "KiB", "MiB", "GiB"} {
+		if value < 1024 || unit == "GiB" {
+			if unit == "B" {
+				return fmt.Sprintf("%d %s", size, unit)
+			}
+			return fmt.Sprintf("%.1f %s", value, unit)
+		}
+		value /= 1024
+	}
+	return ""
+}
+
+// Loads the cache named by the configuration, and lists its entries.
+func getCacheEntries() (*Cache, []*CacheEntry, error) {
+	configureLogging()
+	if _, err := getResolver(); err != nil {
+		return nil, nil, err
+	}
+	entries, err := downloadCache.Entries()
+	if err != nil {
+		return nil, nil, err
+	}
+	return downloadCache, entries, nil
+}
+
+func printCacheEntries(entries []*CacheEntry) {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(writer, "KIND\tSIZE\tLAST USED\tURL\n")
+	for _, entry := range entries {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", entry.Kind, formatSize(entry.Size),
+			entry.LastUsed.Format("2006-01-02 15:04"), entry.Url)
+	}
+	writer.Flush()
+}
+
+func cacheListFn(cmd *Command, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("Too many arguments for 'list'")
+	}
+	cache, entries, err := getCacheEntries()
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, entry := range entries {
+		total += entry.Size
+	}
+	printCacheEntries(entries)
+	fmt.Printf("%d entries, %s in %s\n", len(entries), formatSize(total), cache.Root)
+	return nil
+}
+
+func cachePruneFn(cmd *Command, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("Too many arguments for 'prune'")
+	}
+	if flagOlderThan == "" {
+		return fmt.Errorf("'prune' requires an age, like '--older-than=30d'")
+	}
+	age, err := ParseAge(flagOlderThan)
+	if err != nil {
+		return err
+	}
+	cache, entries, err := getCacheEntries()
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-age)
+	pruned := []*CacheEntry{}
+	var total int64
+	for _, entry := range entries {
+		if entry.LastUsed.After(cutoff) {
+			continue
+		}
+		// another run may be using the entry, or have used it since it was listed
+		unlock := cache.Lock(entry.Path)
+		if info, err := os.Stat(entry.Path + ".url"); err == nil && info.ModTime().After(cutoff) {
+			unlock()
+			continue
+		}
+		err := entry.Remove()
+		unlock()
+		if err != nil {
+			return fmt.Errorf("Cannot remove '%s': %v", entry.Path, err)
+		}
+		pruned = append(pruned, entry)
+		total += entry.Size
+	}
+	printCacheEntries(pruned)
+	fmt.Printf("Pruned %d entries, %s\n", len(pruned), formatSize(total))
+	return nil
+}
+
+func cacheVerifyFn(cmd *Command, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("Too many arguments for 'verify'")
+	}
+	_, entries, err := getCacheEntries()
+	if err != nil {
+		return err
+	}
+	failed := 0
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(writer, "STATUS\tKIND\tSIZE\tLAST USED\tURL\n")
+	for _, entry := range entries {
+		status := "ok"
+		if err := entry.Verify(); err != nil {
+			status = "FAILED: " + err.Error()
+			failed++
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", status, entry.Kind, formatSize(entry.Size),
+			entry.LastUsed.Format("2006-01-02 15:04"), entry.Url)
+	}
+	writer.Flush()
+	if failed > 0 {
+		return fmt.Errorf("%d of %d cache entries failed verification", failed, len(entries))
+	}
+	fmt.Printf("Verified %d entries\n", len(entries))
+	return nil
+}
+
+func cacheClearFn(cmd *Command, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("Too many arguments for 'clear'")
+	}
+	cache, entries, err := getCacheEntries()
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, entry := range entries {
+		total += entry.Size
+	}
+	if err := cache.Clear(); err != nil {
+		return err
+	}
+	fmt.Printf("Removed %d entries, %s\n", len(entries), formatSize(total))
+	return nil
+}
+
+var cacheCmd = Command{
+	Desc: "Manages the download cache.",
+	Help: " Lists, prunes, verifies or clears the cache of git mirrors and downloads.\n" +
+		"\nDefaults:\n" +
+		"  Cache path = " + DefaultCacheRoot + "\n",
+	Commands: CommandMap{
+		"list": &Command{
+			Desc: "Lists cache entries, with their size and when they were last used",
+			Fn:   cacheListFn,
+		},
+		"prune": &Command{
+			Desc:    "Removes cache entries that haven't been used for a while",
+			ArgDesc: "--older-than=[age]",
+			Help:    " Ages are like '30d', '2w' or '12h'.\n",
+			Flags: FlagMap{
+				"older-than": &Flag{
+					Alias:   "o",
+					Desc:    "Remove entries last used longer ago than this",
+					ArgDesc: "[age]",
+					Fn:      StringFlagFn(&flagOlderThan),
+				},
+			},
+			Fn: cachePruneFn,
+		},
+		"verify": &Command{
+			Desc: "Checks git mirrors with fsck, and archives against their digests",
+			Fn:   cacheVerifyFn,
+		},
+		"clear": &Command{
+			Desc: "Removes every cache entry",
+			Fn:   cacheClearFn,
+		},
+	},
+}
@@ -52,13 +52,12 @@ func installFn(cmd *Command, args []string) error {
 	log.Debug("target path: %v", targetPath)
 
 	// get dependencies from the lockfile
-	deplist, err := LoadGrapnelDepsfile(lockFileName)
+	// TODO: fail over to update if there is no lockfile?
+	lockfile, err := loadLockfile()
 	if err != nil {
 		return err
-	} else if deplist == nil {
-		// TODO: fail over to update instead?
-		return fmt.Errorf("Cannot open lock file: '%s'", lockFileName)
 	}
+	deplist := lockfile.Dependencies
 	log.Info("loaded %d dependency definitions", len(deplist))
 
 	log.Info("installing to: %v", targetPath)
@@ -79,14 +78,18 @@ func installFn(cmd *Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	// a lockfile that records the graph needs no scan for more dependencies
+	resolver.LockedGraph = IsLockedGraph(deplist)
 	libs, err = resolver.ResolveDependencies(deplist)
 	if err != nil {
-		return err
+		return offlineError(err)
 	}
 
 	// install all the dependencies
 	log.Info("Resolved %v dependencies. Installing.", len(libs))
-	resolver.InstallLibraries(targetPath, libs)
+	if err := resolver.InstallLibraries(targetPath, libs); err != nil {
+		return err
+	}
 
 	log.Info("Install complete")
 	return nil
@@ -111,6 +114,10 @@ var installCmd = Command{
 			ArgDesc: "[target]",
 			Fn:      StringFlagFn(&targetPath),
 		},
+		"offline": &Flag{
+			Desc: "Use only the download cache, and never the network",
+			Fn:   BoolFlagFn(&flagOffline),
+		},
 	},
 	Fn: installFn,
 }
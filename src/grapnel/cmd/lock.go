@@ -0,0 +1,91 @@
+package cmd
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"fmt"
+	. "grapnel/flag"
+	. "grapnel/lib"
+	log "grapnel/log"
+)
+
+// Loads the lockfile named on the command line, migrated to the current
+// format.
+func loadLockfile() (*Lockfile, error) {
+	if lockFileName == "" {
+		lockFileName = defaultLockFileName
+	}
+	lockfile, err := LoadLockfile(lockFileName)
+	if err != nil {
+		return nil, err
+	} else if lockfile == nil {
+		return nil, fmt.Errorf("Cannot open lock file: '%s'", lockFileName)
+	}
+	if lockfile.Version < LockVersion {
+		log.Info("lock file is lock_version %d; reading it as %d", lockfile.Version, LockVersion)
+	}
+	return lockfile, nil
+}
+
+func lockMigrateFn(cmd *Command, args []string) error {
+	configureLogging()
+
+	if len(args) > 0 {
+		return fmt.Errorf("Too many arguments for 'migrate'")
+	}
+
+	lockfile, err := loadLockfile()
+	if err != nil {
+		return err
+	}
+	if lockfile.Version == LockVersion {
+		fmt.Printf("%s is already lock_version %d\n", lockFileName, LockVersion)
+		return nil
+	}
+	if err := WriteLockfile(lockFileName, lockfile.Libraries()); err != nil {
+		return err
+	}
+	fmt.Printf("Migrated %s from lock_version %d to %d\n", lockFileName, lockfile.Version, LockVersion)
+	return nil
+}
+
+var lockCmd = Command{
+	Desc: "Manages the lock file.",
+	Help: " Works on the lock file written by 'update'.\n" +
+		"\nDefaults:\n" +
+		"  Lock file = " + defaultLockFileName + "\n",
+	Commands: CommandMap{
+		"migrate": &Command{
+			Desc: "Rewrites the lock file in the current format",
+			Flags: FlagMap{
+				"lockfile": &Flag{
+					Alias:   "l",
+					Desc:    "Grapnel lock file",
+					ArgDesc: "[filename]",
+					Fn:      StringFlagFn(&lockFileName),
+				},
+			},
+			Fn: lockMigrateFn,
+		},
+	},
+}
@@ -52,16 +52,42 @@ var (
 	flagQuiet   bool
 	flagVerbose bool
 	flagDebug   bool
+	flagOffline bool
+
+	downloadCache *Cache
 )
 
 func getResolver() (*Resolver, error) {
+	cacheRoot, err := AbsolutePath(DefaultCacheRoot)
+	if err != nil {
+		return nil, err
+	}
+	cache := NewCache(cacheRoot)
+	cache.Offline = flagOffline
+	downloadCache = cache
+
 	resolver := NewResolver()
-	resolver.LibSources["git"] = &GitSCM{}
-	resolver.LibSources["archive"] = &ArchiveSCM{}
+	resolver.LibSources["git"] = &GitSCM{Cache: cache}
+	resolver.LibSources["archive"] = &ArchiveSCM{Cache: cache}
+	resolver.LibSources["hg"] = &HgSCM{}
+	resolver.LibSources["svn"] = &SvnSCM{}
+	resolver.LibSources["bzr"] = &BzrSCM{}
+	resolver.LibSources["goproxy"] = &GoProxySCM{Cache: cache}
+	resolver.LibSources["release"] = &ReleaseSCM{Cache: cache}
+
+	// these keep nothing in the cache, and so can't work offline
+	if flagOffline {
+		for _, name := range []string{"hg", "svn", "bzr"} {
+			resolver.LibSources[name] = &OfflineSCM{Cache: cache}
+		}
+	}
 
 	resolver.AddRewriteRules(BasicRewriteRules)
 	resolver.AddRewriteRules(GitRewriteRules)
 	resolver.AddRewriteRules(ArchiveRewriteRules)
+	resolver.AddRewriteRules(HgRewriteRules)
+	resolver.AddRewriteRules(SvnRewriteRules)
+	resolver.AddRewriteRules(BzrRewriteRules)
 
 	// find/validate configuration file
 	if configFileName != "" {
@@ -95,9 +121,53 @@ func getResolver() (*Resolver, error) {
 		resolver.AddRewriteRules(rules)
 	}
 
+	// load the remaining settings
+	config, err := LoadConfig(configFileName)
+	if err != nil {
+		return nil, err
+	}
+	if config.CachePath != "" {
+		cache.Root = config.CachePath
+	}
+	// the store is opt-in, since it leaves installed files read-only
+	if config.StorePath != "" {
+		resolver.Store = NewStore(config.StorePath)
+	}
+	resolver.LibSources["goproxy"] = &GoProxySCM{
+		BaseUrl: config.GoProxyUrl,
+		Cache:   cache,
+	}
+	resolver.LibSources["release"] = &ReleaseSCM{
+		Apis:        config.ReleaseApis,
+		Credentials: config.Credentials,
+		Cache:       cache,
+	}
+	resolver.LibSources["git"] = &GitSCM{
+		Keyring:     config.Keyring,
+		Credentials: config.Credentials,
+		Cache:       cache,
+	}
+	resolver.LibSources["archive"] = &ArchiveSCM{
+		Credentials: config.Credentials,
+		Cache:       cache,
+	}
+
 	return resolver, nil
 }
 
+// Explains a failed resolve while offline, by listing what the cache lacked.
+func offlineError(err error) error {
+	if !flagOffline || downloadCache == nil {
+		return err
+	}
+	missing := downloadCache.MissingEntries()
+	if len(missing) == 0 {
+		return err
+	}
+	return fmt.Errorf("Cannot resolve dependencies offline; missing from the cache:\n  %s",
+		strings.Join(missing, "\n  "))
+}
+
 func configureLogging() {
 	if flagDebug {
 		log.SetGlobalLogLevel(log.DEBUG)
@@ -146,8 +216,11 @@ var rootCmd = &Command{
 		},
 	},
 	Commands: CommandMap{
+		"cache":   &cacheCmd,
 		"install": &installCmd,
+		"lock":    &lockCmd,
 		"update":  &updateCmd,
+		"verify":  &verifyCmd,
 		"version": &Command{
 			Desc: "Version information",
 			Fn:   SimpleCommandFn(ShowVersion),
@@ -166,5 +239,6 @@ func GrapnelMain() {
 	if err := rootCmd.Execute(os.Args...); err != nil {
 		log.Error(err)
 		rootCmd.ShowHelp(os.Args[0])
+		os.Exit(1)
 	}
 }
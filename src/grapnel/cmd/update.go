@@ -32,7 +32,8 @@ import (
 )
 
 var (
-	createDsd bool = false
+	createDsd    bool = false
+	strategyName string
 )
 
 func updateFn(cmd *Command, args []string) error {
@@ -72,11 +73,14 @@ func updateFn(cmd *Command, args []string) error {
 	}
 	log.Info("loaded %d dependency definitions", len(deplist))
 
-	// open it now before we expend any real effort
-	lockFile, err := os.Create(lockFileName)
-	defer lockFile.Close()
+	// the command line strategy takes precedence over the package file
+	var strategy int
+	if strategyName != "" {
+		strategy, err = ParseStrategy(strategyName)
+	} else {
+		strategy, err = LoadPackageStrategy(packageFileName)
+	}
 	if err != nil {
-		log.Error("Cannot open lock file: '%s'", lockFileName)
 		return err
 	}
 
@@ -98,19 +102,22 @@ func updateFn(cmd *Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	resolver.Strategy = strategy
 	libs, err = resolver.ResolveDependencies(deplist)
 	if err != nil {
-		return err
+		return offlineError(err)
 	}
 
 	// install all the dependencies
 	log.Info("Resolved %v dependencies. Installing.", len(libs))
-	resolver.InstallLibraries(targetPath, libs)
+	if err := resolver.InstallLibraries(targetPath, libs); err != nil {
+		return err
+	}
 
 	// write the library data out
 	log.Info("Writing lock file")
-	for _, lib := range libs {
-		lib.ToToml(lockFile)
+	if err := WriteLockfile(lockFileName, libs); err != nil {
+		return err
 	}
 
 	if createDsd {
@@ -151,6 +158,16 @@ var updateCmd = Command{
 			ArgDesc: "[target]",
 			Fn:      StringFlagFn(&targetPath),
 		},
+		"offline": &Flag{
+			Desc: "Use only the download cache, and never the network",
+			Fn:   BoolFlagFn(&flagOffline),
+		},
+		"strategy": &Flag{
+			Alias:   "s",
+			Desc:    "Version selection strategy: 'newest' or 'minimal'",
+			ArgDesc: "[strategy]",
+			Fn:      StringFlagFn(&strategyName),
+		},
 		"generate-dsd": &Flag{
 			Alias: "g",
 			Desc:  "Create a 'dead-simple-downloader' script'",
@@ -0,0 +1,173 @@
+package cmd
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"fmt"
+	. "grapnel/flag"
+	. "grapnel/lib"
+	log "grapnel/log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Gets the manifest of the tree that was installed for 'dep': from the store
+// if it has an intact copy, and otherwise by fetching the dependency again.
+func getExpectedManifest(resolver *Resolver, dep *Dependency) (map[string]string, error) {
+	expectedHash := dep.TreeHash
+	if resolver.Store != nil && Exists(resolver.Store.Path(expectedHash)) {
+		// only trust the stored copy if it still matches its hash
+		manifest, err := TreeManifest(resolver.Store.Path(expectedHash))
+		if err == nil && ManifestHash(manifest) == expectedHash {
+			return manifest, nil
+		}
+		log.Warn("Store entry for %s doesn't match its hash; fetching again", dep.Import)
+	}
+	lib, err := resolver.Resolve(dep)
+	if err != nil {
+		return nil, offlineError(err)
+	}
+	defer os.RemoveAll(lib.TempDir)
+	manifest, err := TreeManifest(lib.Root())
+	if err != nil {
+		return nil, err
+	}
+	if hash := ManifestHash(manifest); hash != expectedHash {
+		return nil, fmt.Errorf("a fresh fetch doesn't match the lockfile either: got %s", hash)
+	}
+	return manifest, nil
+}
+
+func verifyFn(cmd *Command, args []string) error {
+	configureLogging()
+
+	if len(args) > 0 {
+		return fmt.Errorf("Too many arguments for 'verify'")
+	}
+
+	// set unset paramters to the defaults
+	if lockFileName == "" {
+		lockFileName = defaultLockFileName
+	}
+	if targetPath == "" {
+		targetPath = defaultTargetPath
+	}
+
+	log.Debug("lock file: %v", lockFileName)
+	log.Debug("target path: %v", targetPath)
+
+	lockfile, err := loadLockfile()
+	if err != nil {
+		return err
+	}
+	deplist := lockfile.Dependencies
+	resolver, err := getResolver()
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, dep := range deplist {
+		if dep.TreeHash == "" {
+			log.Warn("No tree hash recorded for '%s'; skipping", dep.Import)
+			continue
+		}
+		importPath := filepath.Join(targetPath, dep.Import)
+		actual := map[string]string{}
+		if Exists(importPath) {
+			if actual, err = TreeManifest(importPath); err != nil {
+				return err
+			}
+		}
+
+		// leave out libraries that are installed inside of this one
+		for _, other := range deplist {
+			if prefix := dep.Import + "/"; strings.HasPrefix(other.Import, prefix) {
+				nested := strings.TrimPrefix(other.Import, prefix) + "/"
+				for name := range actual {
+					if strings.HasPrefix(name, nested) {
+						delete(actual, name)
+					}
+				}
+			}
+		}
+		if ManifestHash(actual) == dep.TreeHash {
+			fmt.Printf("ok      %s\n", dep.Import)
+			continue
+		}
+
+		failed++
+		fmt.Printf("FAILED  %s\n", dep.Import)
+		expected, err := getExpectedManifest(resolver, dep)
+		if err != nil {
+			fmt.Printf("  tree hash mismatch, and cannot get the original to compare: %v\n", err)
+			continue
+		}
+		modified, missing, extra := DiffManifests(expected, actual)
+		for _, item := range []struct {
+			Label string
+			Names []string
+		}{
+			{"modified", modified},
+			{"missing", missing},
+			{"extra", extra},
+		} {
+			for _, name := range item.Names {
+				fmt.Printf("  %-9s %s\n", item.Label+":", name)
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d libraries failed verification", failed, len(deplist))
+	}
+	return nil
+}
+
+var verifyCmd = Command{
+	Desc: "Checks installed dependencies against the lock file.",
+	Help: " Re-hashes each library installed at 'targetPath', and reports files that\n" +
+		" were modified, are missing, or were added since it was installed.\n" +
+		"\nDefaults:\n" +
+		"  Lock file = " + defaultLockFileName + "\n" +
+		"  Target path = " + defaultTargetPath + "\n",
+	Flags: FlagMap{
+		"lockfile": &Flag{
+			Alias:   "l",
+			Desc:    "Grapnel lock file",
+			ArgDesc: "[filename]",
+			Fn:      StringFlagFn(&lockFileName),
+		},
+		"target": &Flag{
+			Alias:   "t",
+			Desc:    "Target installation path",
+			ArgDesc: "[target]",
+			Fn:      StringFlagFn(&targetPath),
+		},
+		"offline": &Flag{
+			Desc: "Use only the download cache, and never the network",
+			Fn:   BoolFlagFn(&flagOffline),
+		},
+	},
+	Fn: verifyFn,
+}
@@ -143,6 +143,14 @@ func (self *Command) Execute(args ...string) error {
 						//--opt =value
 						value = args[ii][1:]
 					}
+				} else {
+					//--opt value, if the flag takes one
+					if consumed, err := dispatchFlag(cmdName, flags, name, args[ii+1:]); err != nil {
+						return err
+					} else {
+						ii += consumed
+					}
+					continue
 				}
 				// dispatch the flag
 				if _, err := dispatchFlag(cmdName, flags, name, []string{value}); err != nil {
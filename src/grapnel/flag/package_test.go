@@ -117,6 +117,11 @@ func TestExecute(t *testing.T) {
 			false, "foobar", false,
 			[]string{},
 		},
+		{
+			[]string{"cmd", "--target", "foobar"},
+			false, "foobar", false,
+			[]string{},
+		},
 		{
 			[]string{"cmd", "-qt", "foobar"},
 			true, "foobar", false,
@@ -132,6 +137,11 @@ func TestExecute(t *testing.T) {
 			true, "foo", true,
 			[]string{"bar", "baz"},
 		},
+		{
+			[]string{"cmd", "test", "--quiet", "foo", "--target", "bar", "baz"},
+			true, "bar", true,
+			[]string{"foo", "baz"},
+		},
 	} {
 		resetCmdTest()
 		if err := rootCmd.Execute(data.Args...); err != nil {
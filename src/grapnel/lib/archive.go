@@ -23,63 +23,305 @@ THE SOFTWARE.
 */
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	log "grapnel/log"
+	url "grapnel/url"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
-	"path/filepath"
+	"path"
+	"regexp"
+	"strings"
 )
 
 var ArchiveRewriteRules = RewriteRuleArray{
+	TypeResolverRule("url_template", `.+`, `archive`),
 	TypeResolverRule("path", `^.*\.zip$`, `archive`),
-	TypeResolverRule("path", `^.*\.tar.gz$`, `archive`),
+	TypeResolverRule("path", `^.*\.(tar\.gz|tgz)$`, `archive`),
+	TypeResolverRule("path", `^.*\.(tar\.bz2|tbz2)$`, `archive`),
+	TypeResolverRule("path", `^.*\.(tar\.xz|txz)$`, `archive`),
 	TypeResolverRule("path", `^.*\.tar$`, `archive`),
 }
 
-type ArchiveSCM struct{}
+type ArchiveSCM struct {
+	Credentials Credentials // per-host HTTP credentials
+	Cache       *Cache      // downloads kept between runs; optional
+}
 
-func (self *ArchiveSCM) Resolve(dep *Dependency) (*Library, error) {
-	lib := NewLibrary(dep)
+// Compares an expected hex digest against a computed one.  An empty
+// expectation always passes.
+func verifyDigest(name, expected string, computed []byte) error {
+	if expected == "" {
+		return nil
+	}
+	if actual := hex.EncodeToString(computed); !strings.EqualFold(expected, actual) {
+		return fmt.Errorf("%s mismatch: expected %s, got %s", name, expected, actual)
+	}
+	return nil
+}
 
-	// create a dedicated directory and a context for commands
-	tempRoot, err := ioutil.TempDir("", "")
+var (
+	indexVersionField = regexp.MustCompile(`\{\{\s*\.version\s*\}\}`)
+	indexHref         = regexp.MustCompile(`(?i)href\s*=\s*["']([^"']+)["']`)
+)
+
+// Scrapes the versions available in an index, which may be a JSON array or
+// an HTML listing.  Entries are matched against the file name portion of
+// 'urlTemplate'; JSON entries that don't match are taken as bare versions.
+func ScrapeIndexVersions(data []byte, urlTemplate string) ([]string, error) {
+	// build a matcher for file names out of the template
+	parts := indexVersionField.Split(path.Base(urlTemplate), -1)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("'url_template' must contain '{{.version}}' in its file name once")
+	}
+	matcher := regexp.MustCompile("^" + regexp.QuoteMeta(parts[0]) + "(.+?)" +
+		regexp.QuoteMeta(parts[1]) + "$")
+
+	candidates := []string{}
+	isJson := false
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		isJson = true
+		entries := []interface{}{}
+		if err := json.Unmarshal(trimmed, &entries); err != nil {
+			return nil, fmt.Errorf("Cannot parse index: %v", err)
+		}
+		for _, entry := range entries {
+			candidates = append(candidates, fmt.Sprint(entry))
+		}
+	} else {
+		for _, match := range indexHref.FindAllSubmatch(data, -1) {
+			href := strings.SplitN(string(match[1]), "?", 2)[0]
+			candidates = append(candidates, href)
+		}
+	}
+
+	results := []string{}
+	seen := map[string]bool{}
+	for _, candidate := range candidates {
+		version := ""
+		if match := matcher.FindStringSubmatch(path.Base(candidate)); match != nil {
+			version = match[1]
+		} else if isJson {
+			version = candidate
+		}
+		if version != "" && !seen[version] {
+			seen[version] = true
+			results = append(results, version)
+		}
+	}
+	return results, nil
+}
+
+// Picks a version from the dependency's index, unless one is pinned by the
+// tag, and builds the download url out of the url template.
+func (self *ArchiveSCM) resolveTemplate(lib *Library) error {
+	if lib.Tag == "" {
+		if lib.Index == "" {
+			return fmt.Errorf("'url_template' requires an 'index' or a 'tag'")
+		}
+		data, err := self.Cache.Metadata(lib.Index, func() ([]byte, error) {
+			response, err := httpGet(lib.Index, self.Credentials)
+			if err != nil {
+				return nil, err
+			}
+			defer response.Body.Close()
+			return ioutil.ReadAll(response.Body)
+		})
+		if err != nil {
+			return fmt.Errorf("Cannot download index: %v", err)
+		}
+		candidates, err := ScrapeIndexVersions(data, lib.UrlTemplate)
+		if err != nil {
+			return err
+		}
+
+		if lib.Tag, lib.Version, err = SelectTag(lib.VersionSpec, lib.Strategy, candidates); err != nil {
+			return err
+		}
+	} else if ver, err := ParseVersion(lib.Tag); err == nil {
+		lib.Version = ver
+	} else {
+		lib.Version = NewVersion(-1, -1, -1)
+	}
+
+	// build the url
+	tmpl, err := RewriteTemplate(lib.UrlTemplate)
+	if err != nil {
+		return fmt.Errorf("Bad 'url_template': %v", err)
+	}
+	values := lib.Flatten()
+	values["version"] = lib.Tag
+	writer := &bytes.Buffer{}
+	if err := tmpl.Execute(writer, values); err != nil {
+		return fmt.Errorf("Error executing 'url_template': %v", err)
+	}
+	if lib.Url, err = url.Parse(writer.String()); err != nil {
+		return fmt.Errorf("Bad url from 'url_template': %v", err)
+	}
+	log.Info("Using archive version %s: %s", lib.Tag, lib.Url.String())
+	return nil
+}
+
+// Fetches 'rawUrl', failing on anything but a 200 response.  The caller is
+// responsible for closing the response body.
+func httpGet(rawUrl string, creds Credentials) (*http.Response, error) {
+	request, err := http.NewRequest("GET", rawUrl, nil)
 	if err != nil {
 		return nil, err
 	}
-	lib.TempDir = tempRoot
-	cmd := NewRunContext(tempRoot)
+	if cred := creds.Find(rawUrl); cred != nil {
+		if header, err := cred.AuthHeader(); err != nil {
+			return nil, err
+		} else if header != "" {
+			request.Header.Set("Authorization", header)
+		}
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, fmt.Errorf("'%s': %s", rawUrl, response.Status)
+	}
+	return response, nil
+}
+
+// Checks the archive at 'filename' against the library's digests, and
+// records its sha256.
+func verifyArchive(lib *Library, archiveUrl, filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	sha256Hash := sha256.New()
+	sha512Hash := sha512.New()
+	if _, err := io.Copy(io.MultiWriter(sha256Hash, sha512Hash), file); err != nil {
+		return fmt.Errorf("Cannot read archive: %v", err)
+	}
+	if err := verifyDigest("sha256", lib.Sha256, sha256Hash.Sum(nil)); err != nil {
+		return fmt.Errorf("While verifying '%s': %v", archiveUrl, err)
+	}
+	if err := verifyDigest("sha512", lib.Sha512, sha512Hash.Sum(nil)); err != nil {
+		return fmt.Errorf("While verifying '%s': %v", archiveUrl, err)
+	}
+	lib.Sha256 = hex.EncodeToString(sha256Hash.Sum(nil))
+	return nil
+}
 
-	// prep archive file for write
-	filename := filepath.Join(tempRoot, filepath.Base(lib.Dependency.Url.Path))
-	file, err := os.OpenFile(filename, os.O_CREATE, 0)
+// Downloads 'archiveUrl' to a new temporary file, and returns its name.
+func downloadArchive(archiveUrl string, creds Credentials) (string, error) {
+	file, err := ioutil.TempFile("", "")
 	if err != nil {
-		return nil, fmt.Errorf("Cannot open archive for writing: %v", err)
+		return "", fmt.Errorf("Cannot open archive for writing: %v", err)
 	}
 	defer file.Close()
 
-	// get the targeted archive
-	response, err := http.Get(lib.Dependency.Url.String())
+	response, err := httpGet(archiveUrl, creds)
+	if err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("Cannot download archive: %v", err)
+	}
+	defer response.Body.Close()
+	if _, err := io.Copy(file, response.Body); err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("Cannot write archive: %v", err)
+	}
+	log.Info("Wrote: %s", file.Name())
+	return file.Name(), nil
+}
+
+// Downloads the archive at 'archiveUrl', verifies it against the library's
+// digests, and extracts it into a new TempDir for the library.  With a cache,
+// a copy from an earlier run is used instead of downloading, provided the
+// library has a digest to check it against or the cache is offline.
+func fetchArchive(lib *Library, archiveUrl string, strip int, creds Credentials, cache *Cache) error {
+	// create a dedicated directory
+	tempRoot, err := ioutil.TempDir("", "")
 	if err != nil {
-		return nil, fmt.Errorf("Cannot download archive: %v", err)
+		return err
+	}
+	lib.TempDir = tempRoot
+	lib.ArchiveUrl = archiveUrl
+	lib.ArchiveStrip = strip
+
+	// offline, the cached copy is used even without a digest to check it against
+	filename := ""
+	if cache != nil {
+		cached := cache.ArchivePath(archiveUrl)
+		defer cache.Lock(cached)()
+		if !Exists(cached) {
+			if cache.Offline {
+				return cache.Missing("archive", archiveUrl)
+			}
+		} else if cache.Offline || lib.Sha256 != "" || lib.Sha512 != "" {
+			if err := verifyArchive(lib, archiveUrl, cached); err == nil {
+				log.Info("Using cached archive for: '%s'", archiveUrl)
+				cache.MarkUsed(cached)
+				filename = cached
+			} else if cache.Offline {
+				return err
+			} else {
+				log.Warn("Discarding cached archive: %v", err)
+			}
+		}
 	}
-	if err := response.Write(file); err != nil {
-		return nil, fmt.Errorf("Cannot write archive: %v", err)
+
+	// verify the download before anything is extracted
+	if filename == "" {
+		download, err := downloadArchive(archiveUrl, creds)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(download) // gone already, once moved into the cache
+		if err := verifyArchive(lib, archiveUrl, download); err != nil {
+			return err
+		}
+		filename = download
+		if cache != nil {
+			if cached, err := cache.StoreArchive(archiveUrl, download); err != nil {
+				log.Warn("%v", err)
+			} else {
+				filename = cached
+			}
+		}
 	}
-	file.Close()
-	log.Info("Wrote: %s", filename)
 
 	// extract the file
-	// TODO: change to using built-in libraries whenever possible.
-	switch filepath.Ext(filename) {
-	case "zip":
-		cmd.Run("unzip", filename)
-	case "tar.gz":
-		cmd.Run("tar", "xzf", filename)
-	case "tar":
-		cmd.Run("tar", "xf", filename)
+	if err := ExtractArchive(tempRoot, filename, strip); err != nil {
+		return fmt.Errorf("Cannot extract archive '%s': %v", archiveUrl, err)
+	}
+	return nil
+}
+
+func (self *ArchiveSCM) Resolve(dep *Dependency) (*Library, error) {
+	lib := NewLibrary(dep)
+
+	if lib.Url == nil && lib.UrlTemplate == "" {
+		return nil, fmt.Errorf("Cannot download archive dependency without a 'url' or 'url_template': '%s'", lib.Import)
+	}
+	if lib.UrlTemplate != "" {
+		if err := self.resolveTemplate(lib); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := fetchArchive(lib, lib.Url.String(), lib.StripComponents, self.Credentials, self.Cache); err != nil {
+		return nil, err
+	}
+
+	// Stop now if the index provided the version
+	if lib.Version != nil {
+		log.Info("Resolved: %s %v", lib.Import, lib.Version)
+		return lib, nil
 	}
-	os.Remove(filename)
 
 	// Stop now if we have no semantic version information
 	if lib.VersionSpec.IsUnversioned() {
@@ -88,8 +330,9 @@ func (self *ArchiveSCM) Resolve(dep *Dependency) (*Library, error) {
 		return lib, nil
 	}
 
-	// get the version number from the filename
-	if ver, err := ParseVersion(filepath.Base(filename)); err == nil {
+	// get the version number from the archive name
+	archiveName := TrimArchiveExt(path.Base(lib.Url.Path))
+	if ver, err := ParseVersion(archiveName); err == nil {
 		log.Debug("ver: %v", ver)
 		if dep.VersionSpec.IsSatisfiedBy(ver) {
 			lib.Version = ver
@@ -107,6 +350,6 @@ func (self *ArchiveSCM) Resolve(dep *Dependency) (*Library, error) {
 	return lib, nil
 }
 
-func (self *ArchiveSCM) ToDSD(*Library) string {
-	return ""
+func (self *ArchiveSCM) ToDSD(lib *Library) string {
+	return archiveDSD(lib)
 }
@@ -0,0 +1,234 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestArchiveSource(t *testing.T) {
+	data := gzipBytes(buildTestTar(testArchiveEntries))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	dep, err := NewDependency("foo/bar/baz", server.URL+"/foo-1.0.tar.gz", "1.0")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	dep.StripComponents = 1
+
+	libsrc := &ArchiveSCM{}
+	lib, err := libsrc.Resolve(dep)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(lib.TempDir)
+	if lib.Version.Major != 1 || lib.Version.Minor != 0 {
+		t.Errorf("Bad version: '%v'. Expected: '1.0'", lib.Version)
+	}
+	for _, name := range []string{"README", "bar/bar.go"} {
+		if !Exists(filepath.Join(lib.TempDir, name)) {
+			t.Errorf("Missing '%v' from extracted archive", name)
+		}
+	}
+
+	// negative test
+	dep, _ = NewDependency("foo/bar/baz", "", "1.0")
+	if _, err := libsrc.Resolve(dep); err == nil {
+		t.Errorf("Resolved an archive without a url okay")
+	}
+}
+
+func TestArchiveChecksum(t *testing.T) {
+	data := gzipBytes(buildTestTar(testArchiveEntries))
+	sum256 := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum256[:])
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing.tar.gz" {
+			http.NotFound(w, r)
+		} else {
+			w.Write(data)
+		}
+	}))
+	defer server.Close()
+	libsrc := &ArchiveSCM{}
+
+	// the computed digest is recorded, and verified when present
+	for _, expected := range []string{"", digest, strings.ToUpper(digest)} {
+		dep, err := NewDependency("foo/bar/baz", server.URL+"/foo.tar.gz", "")
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		dep.Sha256 = expected
+		lib, err := libsrc.Resolve(dep)
+		if err != nil {
+			t.Errorf("Error resolving with sha256 '%v': %v", expected, err)
+			continue
+		}
+		defer os.RemoveAll(lib.TempDir)
+		if lib.Sha256 != digest {
+			t.Errorf("Bad value for sha256: '%v'. Expected: '%v'", lib.Sha256, digest)
+		}
+	}
+
+	// negative tests
+	dep, _ := NewDependency("foo/bar/baz", server.URL+"/foo.tar.gz", "")
+	dep.Sha256 = strings.Repeat("0", 64)
+	if _, err := libsrc.Resolve(dep); err == nil {
+		t.Errorf("Tampered archive resolved okay")
+	}
+	dep, _ = NewDependency("foo/bar/baz", server.URL+"/foo.tar.gz", "")
+	dep.Sha512 = strings.Repeat("0", 128)
+	if _, err := libsrc.Resolve(dep); err == nil {
+		t.Errorf("Tampered archive resolved okay")
+	}
+	dep, _ = NewDependency("foo/bar/baz", server.URL+"/missing.tar.gz", "")
+	if _, err := libsrc.Resolve(dep); err == nil {
+		t.Errorf("Missing archive resolved okay")
+	}
+}
+
+func TestScrapeIndexVersions(t *testing.T) {
+	urlTemplate := "https://example.com/releases/foo-{{.version}}.tar.gz"
+	for _, test := range []struct {
+		Index    string
+		Expected []string
+	}{
+		{`<a href="foo-1.0.tar.gz">foo-1.0</a> <a href='/releases/foo-1.2.tar.gz?x=1'>`,
+			[]string{"1.0", "1.2"}},
+		{`<a href="bar-1.0.tar.gz"></a><a href="foo-1.0.zip"></a><a href="foo-2.0.tar.gz"></a>`,
+			[]string{"2.0"}},
+		{` ["foo-1.0.tar.gz", "1.1", "foo-1.0.tar.gz"]`, []string{"1.0", "1.1"}},
+		{`no links here`, []string{}},
+	} {
+		versions, err := ScrapeIndexVersions([]byte(test.Index), urlTemplate)
+		if err != nil {
+			t.Errorf("Error scraping '%v': %v", test.Index, err)
+		} else if strings.Join(versions, ",") != strings.Join(test.Expected, ",") {
+			t.Errorf("Bad versions from '%v': %v. Expected: %v", test.Index, versions, test.Expected)
+		}
+	}
+
+	// negative tests
+	for _, badTemplate := range []string{
+		"https://example.com/foo.tar.gz",
+		"https://example.com/{{.version}}/foo.tar.gz",
+		"https://example.com/foo-{{.version}}-{{.version}}.tar.gz",
+	} {
+		if _, err := ScrapeIndexVersions([]byte(`[]`), badTemplate); err == nil {
+			t.Errorf("Template without a single version in the file name worked: %v", badTemplate)
+		}
+	}
+	if _, err := ScrapeIndexVersions([]byte(`[1, `), urlTemplate); err == nil {
+		t.Errorf("Malformed JSON index scraped okay")
+	}
+}
+
+func TestArchiveUrlTemplate(t *testing.T) {
+	data := gzipBytes(buildTestTar(testArchiveEntries))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/releases/":
+			w.Write([]byte(`<a href="foo-1.0.tar.gz"></a> <a href="foo-1.5.tar.gz"></a>
+				<a href="foo-2.0.tar.gz"></a>`))
+		case "/releases.json":
+			w.Write([]byte(`["1.0", "1.5", "2.0"]`))
+		case "/releases/foo-1.0.tar.gz", "/releases/foo-1.5.tar.gz", "/releases/foo-2.0.tar.gz":
+			w.Write(data)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	urlTemplate := server.URL + "/releases/foo-{{.version}}.tar.gz"
+	libsrc := &ArchiveSCM{}
+
+	for _, test := range []struct {
+		Index    string
+		Spec     string
+		Strategy int
+		Tag      string
+		Expected string
+	}{
+		{"/releases/", "", StrategyNewest, "", "2.0"},
+		{"/releases/", "1", StrategyNewest, "", "1.5"},
+		{"/releases/", ">=1", StrategyMinimal, "", "1.0"},
+		{"/releases.json", "<2", StrategyNewest, "", "1.5"},
+		{"/missing", "", StrategyNewest, "1.0", "1.0"},
+	} {
+		dep, err := NewDependency("foo/bar/baz", "", test.Spec)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		dep.UrlTemplate = urlTemplate
+		dep.Index = server.URL + test.Index
+		dep.Strategy = test.Strategy
+		dep.Tag = test.Tag
+		dep.StripComponents = 1
+		lib, err := libsrc.Resolve(dep)
+		if err != nil {
+			t.Errorf("Error resolving %v from '%v': %v", test.Spec, test.Index, err)
+			continue
+		}
+		defer os.RemoveAll(lib.TempDir)
+		if lib.Tag != test.Expected {
+			t.Errorf("Bad tag for %v from '%v': '%v'. Expected: '%v'",
+				test.Spec, test.Index, lib.Tag, test.Expected)
+		}
+		expectedUrl := server.URL + "/releases/foo-" + test.Expected + ".tar.gz"
+		if lib.Url.String() != expectedUrl {
+			t.Errorf("Bad url: '%v'. Expected: '%v'", lib.Url.String(), expectedUrl)
+		}
+		if !Exists(filepath.Join(lib.TempDir, "README")) {
+			t.Errorf("Missing 'README' from extracted archive")
+		}
+	}
+
+	// negative tests
+	for _, test := range []struct {
+		Index string
+		Spec  string
+	}{
+		{"/releases/", "3"},
+		{"/missing", ""},
+		{"", ""},
+	} {
+		dep, _ := NewDependency("foo/bar/baz", "", test.Spec)
+		dep.UrlTemplate = urlTemplate
+		if test.Index != "" {
+			dep.Index = server.URL + test.Index
+		}
+		if _, err := libsrc.Resolve(dep); err == nil {
+			t.Errorf("Resolved %v from '%v' okay", test.Spec, test.Index)
+		}
+	}
+}
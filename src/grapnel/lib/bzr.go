@@ -0,0 +1,165 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"fmt"
+	log "grapnel/log"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var BzrRewriteRules = RewriteRuleArray{
+	// rewrite rules for misc bazaar resolvers
+	TypeResolverRule("scheme", `^bzr(\+ssh)?$`, `bzr`),
+	TypeResolverRule("host", `^launchpad\.net$`, `bzr`),
+	TypeResolverRule("host", `^bazaar\.launchpad\.net$`, `bzr`),
+
+	// support for launchpad.net/project and launchpad.net/~user/project/branch
+	BuildRewriteRule(StringMap{
+		"type": `bzr`,
+		"host": `^launchpad\.net$`,
+	}, StringMap{
+		"scheme": `https`,
+		"path":   `{{ replace .path "^/(~[^/]+/[^/]+/[^/]+|[^/]+).*$" "/$1" }}`,
+		"import": `{{ replace .import "^launchpad.net/(~[^/]+/[^/]+/[^/]+|[^/]+).*$" "launchpad.net/$1" }}`,
+	}),
+}
+
+type BzrSCM struct{}
+
+var bzrRevisionSpec = regexp.MustCompile(`^(\d+|[a-z]+:.*)$`)
+
+func stripBzrRepo(baseDir string) {
+	os.RemoveAll(path.Join(baseDir, ".bzr"))
+}
+
+// Converts a tag into a bazaar revision spec.  Revision numbers and explicit
+// specs like 'revid:...' are used as-is; anything else names a tag.
+func bzrRevision(tag string) string {
+	if bzrRevisionSpec.MatchString(tag) {
+		return tag
+	}
+	return "tag:" + tag
+}
+
+func (self *BzrSCM) Resolve(dep *Dependency) (*Library, error) {
+	lib := NewLibrary(dep)
+
+	if lib.Url == nil {
+		return nil, fmt.Errorf("Cannot download bzr dependency without a url: '%s'", lib.Import)
+	}
+	if lib.Branch != "" {
+		log.Warn("Ignoring branch '%s' for bzr dependency: '%s'", lib.Branch, lib.Import)
+		lib.Branch = ""
+	}
+	branchUrl := lib.Url.String()
+
+	log.Info("Fetching Bzr Dependency: '%s'", lib.Import)
+
+	// create a dedicated directory and a context for commands
+	tempRoot, err := ioutil.TempDir("", "")
+	if err != nil {
+		return nil, err
+	}
+	lib.TempDir = tempRoot
+	cmd := NewRunContext(tempRoot)
+
+	// pick a tag by version, unless the dependency names one
+	pinnedTag := lib.Tag
+	if lib.VersionSpec.IsUnversioned() {
+		lib.Version = NewVersion(-1, -1, -1)
+	} else if pinnedTag == "" {
+		if err := cmd.Run("bzr", "tags", "--directory", branchUrl); err != nil {
+			return nil, fmt.Errorf("Failed to acquire tag list for depenency")
+		}
+		tags := []string{}
+		for _, line := range strings.Split(cmd.CombinedOutput, "\n") {
+			if fields := strings.Fields(line); len(fields) > 0 {
+				tags = append(tags, fields[0])
+			}
+		}
+		if lib.Tag, lib.Version, err = SelectTag(lib.VersionSpec, lib.Strategy, tags); err != nil {
+			return nil, err
+		}
+	}
+
+	// get the branch at the specified revision, or the tip
+	log.Info("Fetching remote data for %s", lib.Import)
+	args := []string{"branch", "--use-existing-dir"}
+	if lib.Tag != "" {
+		args = append(args, "--revision", bzrRevision(lib.Tag))
+	}
+	if err := cmd.Run("bzr", append(args, branchUrl, tempRoot)...); err != nil {
+		return nil, fmt.Errorf("Cannot download dependency: '%s'", branchUrl)
+	}
+
+	// pin the Tag to the revision ID
+	if err := cmd.Run("bzr", "revision-info", "--directory", tempRoot); err != nil {
+		return nil, fmt.Errorf("Failed to identify revision: '%s'", lib.Tag)
+	}
+	revno := ""
+	if fields := strings.Fields(cmd.CombinedOutput); len(fields) != 2 {
+		return nil, fmt.Errorf("Failed to identify revision: '%s'", lib.Tag)
+	} else {
+		revno = fields[0]
+		lib.Tag = "revid:" + fields[1]
+	}
+
+	// a tag given up front still has to satisfy the version spec, by way of
+	// a version tag on the same revision
+	if lib.Version == nil {
+		if err := cmd.Run("bzr", "tags", "--directory", tempRoot); err != nil {
+			return nil, fmt.Errorf("Failed to acquire tag list for depenency")
+		}
+		tags := []string{}
+		for _, line := range strings.Split(cmd.CombinedOutput, "\n") {
+			if fields := strings.Fields(line); len(fields) == 2 && fields[1] == revno {
+				tags = append(tags, fields[0])
+			}
+		}
+		if _, lib.Version, err = SelectTag(lib.VersionSpec, lib.Strategy, tags); err != nil {
+			return nil, fmt.Errorf("Tag '%s' does not satisfy version specification: %v.", pinnedTag, lib.VersionSpec)
+		}
+	}
+
+	if lib.Version.Major == -1 {
+		log.Warn("Resolved: %v (unversioned)", lib.Import)
+	} else {
+		log.Info("Resolved: %s %v", lib.Import, lib.Version)
+	}
+	stripBzrRepo(lib.TempDir)
+	return lib, nil
+}
+
+func (self *BzrSCM) ToDSD(lib *Library) string {
+	return dsdLines(
+		dsdCommand("bzr", "branch", "--quiet", "--use-existing-dir", "--revision", lib.Tag,
+			lib.Url.String(), "$dir"),
+		dsdCommand("check_equal", "revision", strings.TrimPrefix(lib.Tag, "revid:"),
+			`$(bzr revision-info --directory "$dir" | cut -d' ' -f2)`),
+		dsdCommand("rm", "-rf", "$dir/.bzr"))
+}
@@ -0,0 +1,135 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	. "grapnel/testing"
+	url "grapnel/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestBzrRewrite(t *testing.T) {
+	rules := RewriteRuleArray{}
+	rules = append(rules, BasicRewriteRules...)
+	rules = append(rules, GitRewriteRules...)
+	rules = append(rules, BzrRewriteRules...)
+
+	for _, test := range []struct {
+		Src *Dependency
+		Dst *Dependency
+	}{
+		{
+			Src: &Dependency{
+				Import: "launchpad.net/goyaml",
+			},
+			Dst: &Dependency{
+				Import: "launchpad.net/goyaml",
+				Url:    url.MustParse("https://launchpad.net/goyaml"),
+				Type:   "bzr",
+			},
+		}, {
+			Src: &Dependency{
+				Import: "launchpad.net/~niemeyer/gocheck/trunk/subpkg",
+			},
+			Dst: &Dependency{
+				Import: "launchpad.net/~niemeyer/gocheck/trunk",
+				Url:    url.MustParse("https://launchpad.net/~niemeyer/gocheck/trunk"),
+				Type:   "bzr",
+			},
+		},
+	} {
+		if err := rules.Apply(test.Src); err != nil {
+			t.Errorf("Error during replacement %v; Src: %v", err, test.Src.Flatten())
+		}
+		if !test.Src.Equal(test.Dst) {
+			t.Errorf("Error during replacement Src: %#v; Dst: %#v",
+				test.Src.Flatten(), test.Dst.Flatten())
+		}
+	}
+}
+
+func TestBzrRevision(t *testing.T) {
+	for tag, expected := range map[string]string{
+		"v1.0":              "tag:v1.0",
+		"42":                "42",
+		"revid:foo@bar-123": "revid:foo@bar-123",
+	} {
+		if result := bzrRevision(tag); result != expected {
+			t.Errorf("Tag '%v' mapped to '%v', expected '%v'", tag, result, expected)
+		}
+	}
+}
+
+func TestBzrSource(t *testing.T) {
+	if _, err := exec.LookPath("bzr"); err != nil {
+		t.Skip("bzr is not installed")
+	}
+	InitTestLogging()
+
+	// construct a repo
+	basePath := BuildTestBzrRepo("bzrrepo")
+	defer os.RemoveAll(basePath)
+
+	// map a dependency to the repo
+	dep, err := NewDependency("foo/bar/baz", "file://"+path.Join(basePath, "bzrrepo"), "1.0")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// test the resolver
+	libsrc := &BzrSCM{}
+	lib, err := libsrc.Resolve(dep)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(lib.TempDir)
+	if !strings.HasPrefix(lib.Tag, "revid:") {
+		t.Errorf("Expected a pinned revision ID; got '%v' instead", lib.Tag)
+	}
+	if Exists(path.Join(lib.TempDir, "foo.txt")) {
+		t.Errorf("Expected v1.0 to be checked out")
+	}
+
+	// a pinned revision is kept, rather than the newest matching tag
+	pinned, _ := NewDependency("foo/bar/baz", "file://"+path.Join(basePath, "bzrrepo"), "1.*")
+	pinned.Tag = lib.Tag
+	if relib, err := libsrc.Resolve(pinned); err != nil {
+		t.Errorf("Error resolving pinned revision: %v", err)
+	} else {
+		defer os.RemoveAll(relib.TempDir)
+		if relib.Tag != lib.Tag || relib.Version.String() != lib.Version.String() {
+			t.Errorf("Pinned revision %v resolved to %v (%v)", lib.Tag, relib.Tag, relib.Version)
+		}
+	}
+
+	// ... and has to satisfy the version spec
+	pinned.VersionSpec, _ = ParseVersionSpec("1.1")
+	if relib, err := libsrc.Resolve(pinned); err == nil {
+		defer os.RemoveAll(relib.TempDir)
+		t.Errorf("Pinned revision resolved okay against the wrong version")
+	}
+}
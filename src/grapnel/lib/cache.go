@@ -0,0 +1,431 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	log "grapnel/log"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// default location of the download cache
+const DefaultCacheRoot = "~/.grapnel/cache"
+
+// Downloads that are kept between runs: bare mirrors of git repositories
+// under 'git/', archives under 'archives/', and metadata such as version
+// listings under 'http/'.  Entries are keyed by a hash of their url, and each
+// has a '.url' file next to it naming the original; its modification time is
+// when the entry was last used.  File entries also have a '.sha256' file.
+type Cache struct {
+	Root    string
+	Offline bool // use only what is already cached, and never the network
+
+	mutex   sync.Mutex
+	locks   map[string]*sync.Mutex // per-entry locks, for concurrent resolves
+	missing []string               // entries that were needed while offline
+}
+
+func NewCache(root string) *Cache {
+	return &Cache{
+		Root:  root,
+		locks: map[string]*sync.Mutex{},
+	}
+}
+
+func cacheKey(rawUrl string) string {
+	sum := sha256.Sum256([]byte(rawUrl))
+	return hex.EncodeToString(sum[:])
+}
+
+// Returns the path of the bare mirror for 'repoUrl'.
+func (self *Cache) GitMirrorPath(repoUrl string) string {
+	return filepath.Join(self.Root, "git", cacheKey(repoUrl))
+}
+
+// Returns the path of the cached response for the metadata at 'rawUrl'.
+func (self *Cache) MetadataPath(rawUrl string) string {
+	return filepath.Join(self.Root, "http", cacheKey(rawUrl))
+}
+
+// Returns the path of the cached copy of the archive at 'archiveUrl'.
+func (self *Cache) ArchivePath(archiveUrl string) string {
+	return filepath.Join(self.Root, "archives", cacheKey(archiveUrl))
+}
+
+// Locks the entry at 'entryPath' against other goroutines, and against other
+// processes sharing the cache through an flock on '<entry>.lock'.  Returns
+// the function that unlocks it.
+func (self *Cache) Lock(entryPath string) func() {
+	self.mutex.Lock()
+	lock, ok := self.locks[entryPath]
+	if !ok {
+		lock = &sync.Mutex{}
+		self.locks[entryPath] = lock
+	}
+	self.mutex.Unlock()
+	lock.Lock()
+
+	file, err := lockFile(entryPath + ".lock")
+	if err != nil {
+		// a read-only cache can still be used offline
+		log.Warn("Cannot lock cache entry: %v", err)
+		return lock.Unlock
+	}
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+		lock.Unlock()
+	}
+}
+
+// Opens the lock file at 'filename', and waits for an exclusive flock on it.
+func lockFile(filename string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// Records an entry that the cache lacks while offline, and returns the error
+// for it.
+func (self *Cache) Missing(kind, rawUrl string) error {
+	entry := kind + " '" + rawUrl + "'"
+	self.mutex.Lock()
+	self.missing = append(self.missing, entry)
+	self.mutex.Unlock()
+	return fmt.Errorf("Offline, and the cache has no %s", entry)
+}
+
+// Returns every entry recorded by Missing, sorted and without duplicates.
+func (self *Cache) MissingEntries() []string {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	results := []string{}
+	seen := map[string]bool{}
+	for _, entry := range self.missing {
+		if !seen[entry] {
+			seen[entry] = true
+			results = append(results, entry)
+		}
+	}
+	sort.Strings(results)
+	return results
+}
+
+// Records the url that an entry was downloaded from.
+func writeCacheUrl(entryPath, rawUrl string) error {
+	return ioutil.WriteFile(entryPath+".url", []byte(rawUrl+"\n"), 0644)
+}
+
+// Records the digest of a file entry, for Verify.
+func writeCacheDigest(entryPath string) error {
+	digest, err := fileSha256(entryPath)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(entryPath+".sha256", []byte(digest+"\n"), 0644)
+}
+
+func fileSha256(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Notes that the entry at 'entryPath' was just used.
+func (self *Cache) MarkUsed(entryPath string) {
+	now := time.Now()
+	if err := os.Chtimes(entryPath+".url", now, now); err != nil {
+		log.Debug("Cannot mark cache entry as used: %v", err)
+	}
+}
+
+// Creates or updates the bare mirror of 'repoUrl', fetching only what is new
+// since the last run, and returns its path.  'cmd' supplies the environment
+// for the fetch.
+func (self *Cache) UpdateGitMirror(cmd *RunContext, repoUrl string) (string, error) {
+	mirror := self.GitMirrorPath(repoUrl)
+	defer self.Lock(mirror)()
+
+	if self.Offline {
+		if !Exists(mirror) {
+			return "", self.Missing("git mirror", repoUrl)
+		}
+		self.MarkUsed(mirror)
+		return mirror, nil
+	}
+	if !Exists(mirror) {
+		if err := os.MkdirAll(mirror, 0755); err != nil {
+			return "", fmt.Errorf("Cannot create cache directory: %v", err)
+		}
+		initCmd := NewRunContext(mirror)
+		if err := initCmd.Run("git", "init", "--bare", "--quiet"); err != nil {
+			os.RemoveAll(mirror)
+			return "", fmt.Errorf("Cannot create mirror: %s", strings.TrimSpace(initCmd.CombinedOutput))
+		}
+		if err := writeCacheUrl(mirror, repoUrl); err != nil {
+			return "", err
+		}
+	}
+
+	log.Info("Updating cached mirror of: '%s'", repoUrl)
+	fetchCmd := NewRunContext(mirror)
+	fetchCmd.Env = cmd.Env
+	if err := fetchCmd.Run("git", "fetch", "--quiet", "--prune", repoUrl,
+		"+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*"); err != nil {
+		return "", fmt.Errorf("Cannot fetch into mirror: %s", strings.TrimSpace(fetchCmd.CombinedOutput))
+	}
+	self.MarkUsed(mirror)
+	return mirror, nil
+}
+
+// Moves the downloaded archive at 'filename' into the cache, as the copy of
+// 'archiveUrl', and returns its new path.  The caller holds the entry's lock.
+func (self *Cache) StoreArchive(archiveUrl, filename string) (string, error) {
+	entry := self.ArchivePath(archiveUrl)
+	if err := os.MkdirAll(filepath.Dir(entry), 0755); err != nil {
+		return "", fmt.Errorf("Cannot create cache directory: %v", err)
+	}
+	if err := os.Rename(filename, entry); err != nil {
+		// the download may be on another filesystem
+		if err := CopyFileContents(filename, entry); err != nil {
+			os.Remove(entry)
+			return "", fmt.Errorf("Cannot store archive in cache: %v", err)
+		}
+	}
+	if err := writeCacheDigest(entry); err != nil {
+		return "", err
+	}
+	if err := writeCacheUrl(entry, archiveUrl); err != nil {
+		return "", err
+	}
+	return entry, nil
+}
+
+// Returns the metadata at 'rawUrl', as downloaded by 'fetch'.  Metadata goes
+// stale, so online it is always fetched and the cached copy replaced; offline
+// the cached copy is all there is.  A nil cache simply calls 'fetch'.
+func (self *Cache) Metadata(rawUrl string, fetch func() ([]byte, error)) ([]byte, error) {
+	if self == nil {
+		return fetch()
+	}
+	entry := self.MetadataPath(rawUrl)
+	defer self.Lock(entry)()
+
+	if self.Offline {
+		if !Exists(entry) {
+			return nil, self.Missing("metadata", rawUrl)
+		}
+		self.MarkUsed(entry)
+		return ioutil.ReadFile(entry)
+	}
+	data, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(entry), 0755); err != nil {
+		log.Warn("Cannot create cache directory: %v", err)
+	} else if err := ioutil.WriteFile(entry, data, 0644); err != nil {
+		log.Warn("Cannot store metadata in cache: %v", err)
+	} else if err := writeCacheDigest(entry); err != nil {
+		log.Warn("%v", err)
+	} else if err := writeCacheUrl(entry, rawUrl); err != nil {
+		log.Warn("%v", err)
+	}
+	return data, nil
+}
+
+// the kinds of cache entries, named for the directories that hold them
+const (
+	CacheGit      = "git"
+	CacheArchives = "archives"
+	CacheMetadata = "http"
+)
+
+// An entry in the cache, as listed by Entries
+type CacheEntry struct {
+	Kind     string
+	Path     string
+	Url      string
+	Size     int64     // bytes on disk, including the contents of mirrors
+	LastUsed time.Time // when a run last used the entry
+}
+
+// Lists every entry in the cache, by kind and then by url.
+func (self *Cache) Entries() ([]*CacheEntry, error) {
+	results := []*CacheEntry{}
+	for _, kind := range []string{CacheGit, CacheArchives, CacheMetadata} {
+		kindDir := filepath.Join(self.Root, kind)
+		infos, err := ioutil.ReadDir(kindDir)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		entries := []*CacheEntry{}
+		for _, info := range infos {
+			if strings.Contains(info.Name(), ".") {
+				continue // '.url', '.sha256' and '.lock' files, and partial downloads
+			}
+			entry := &CacheEntry{
+				Kind:     kind,
+				Path:     filepath.Join(kindDir, info.Name()),
+				LastUsed: info.ModTime(),
+			}
+			if data, err := ioutil.ReadFile(entry.Path + ".url"); err == nil {
+				entry.Url = strings.TrimSpace(string(data))
+			}
+			if urlInfo, err := os.Stat(entry.Path + ".url"); err == nil {
+				entry.LastUsed = urlInfo.ModTime()
+			}
+			filepath.Walk(entry.Path, func(name string, info os.FileInfo, err error) error {
+				if err == nil && !info.IsDir() {
+					entry.Size += info.Size()
+				}
+				return nil
+			})
+			entries = append(entries, entry)
+		}
+		sort.Sort(cacheEntriesByUrl(entries))
+		results = append(results, entries...)
+	}
+	return results, nil
+}
+
+type cacheEntriesByUrl []*CacheEntry
+
+func (self cacheEntriesByUrl) Len() int           { return len(self) }
+func (self cacheEntriesByUrl) Swap(i, j int)      { self[i], self[j] = self[j], self[i] }
+func (self cacheEntriesByUrl) Less(i, j int) bool { return self[i].Url < self[j].Url }
+
+// Checks an entry for damage: git mirrors get an fsck, and files are checked
+// against the digest recorded when they were stored.
+func (self *CacheEntry) Verify() error {
+	if self.Kind == CacheGit {
+		cmd := NewRunContext(self.Path)
+		if err := cmd.Run("git", "fsck", "--no-progress"); err != nil {
+			return fmt.Errorf("fsck failed: %s", strings.TrimSpace(cmd.CombinedOutput))
+		}
+		return nil
+	}
+	data, err := ioutil.ReadFile(self.Path + ".sha256")
+	if err != nil {
+		return fmt.Errorf("No digest recorded for entry")
+	}
+	actual, err := fileSha256(self.Path)
+	if err != nil {
+		return err
+	}
+	if expected := strings.TrimSpace(string(data)); actual != expected {
+		return fmt.Errorf("sha256 mismatch: expected %s, got %s", expected, actual)
+	}
+	return nil
+}
+
+// Removes an entry, along with the files that describe it.  The '.lock' file
+// stays, since other runs may be waiting on it.
+func (self *CacheEntry) Remove() error {
+	for _, name := range []string{self.Path + ".url", self.Path + ".sha256"} {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.RemoveAll(self.Path)
+}
+
+// Removes every entry in the cache.
+func (self *Cache) Clear() error {
+	for _, kind := range []string{CacheGit, CacheArchives, CacheMetadata} {
+		if err := os.RemoveAll(filepath.Join(self.Root, kind)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Parses an age like '30d', '2w' or '12h'.  Days and weeks are added to the
+// units that time.ParseDuration understands.
+func ParseAge(value string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{
+		"d": 24 * time.Hour,
+		"w": 7 * 24 * time.Hour,
+	} {
+		if strings.HasSuffix(value, suffix) {
+			count, err := strconv.ParseFloat(strings.TrimSuffix(value, suffix), 64)
+			if err != nil || count < 0 {
+				return 0, fmt.Errorf("Bad age: '%s'", value)
+			}
+			return time.Duration(count * float64(unit)), nil
+		}
+	}
+	age, err := time.ParseDuration(value)
+	if err != nil || age < 0 {
+		return 0, fmt.Errorf("Bad age: '%s'", value)
+	}
+	return age, nil
+}
+
+// Stands in for LibSources that keep nothing in the cache, so that they fail
+// when offline rather than reach for the network.
+type OfflineSCM struct {
+	Cache *Cache
+}
+
+// Records a single miss for 'dep': its url, or its import when the url would
+// be synthesized from it.
+func (self *OfflineSCM) Resolve(dep *Dependency) (*Library, error) {
+	if dep.Url == nil {
+		return nil, self.Cache.Missing(dep.Type+" repository for import", dep.Import)
+	}
+	return nil, self.Cache.Missing(dep.Type+" repository", dep.Url.String())
+}
+
+func (self *OfflineSCM) ToDSD(lib *Library) string {
+	return ""
+}
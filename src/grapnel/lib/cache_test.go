@@ -0,0 +1,410 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	. "grapnel/testing"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGitCache(t *testing.T) {
+	InitTestLogging()
+
+	basePath := BuildTestGitRepo("gitrepo")
+	defer os.RemoveAll(basePath)
+	repoPath := path.Join(basePath, "gitrepo")
+	repoUrl := "file://" + repoPath
+
+	cacheRoot, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(cacheRoot)
+	cache := NewCache(cacheRoot)
+	libsrc := &GitSCM{Cache: cache}
+
+	dep, _ := NewDependency("foo/bar/baz", repoUrl, "1.0")
+	lib, err := libsrc.Resolve(dep)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	os.RemoveAll(lib.TempDir)
+	mirror := cache.GitMirrorPath(repoUrl)
+	if !Exists(mirror) {
+		t.Fatalf("No mirror created for '%v'", repoUrl)
+	}
+	if data, err := ioutil.ReadFile(mirror + ".url"); err != nil || strings.TrimSpace(string(data)) != repoUrl {
+		t.Errorf("Bad url recorded for mirror: '%s' (%v)", data, err)
+	}
+
+	// a later run picks up what is new on the remote
+	cmd := NewRunContext(repoPath)
+	cmd.MustRun("git", "tag", "v1.2")
+	dep, _ = NewDependency("foo/bar/baz", repoUrl, ">=1.2")
+	if lib, err = libsrc.Resolve(dep); err != nil {
+		t.Fatalf("%v", err)
+	}
+	os.RemoveAll(lib.TempDir)
+	if lib.Tag != "v1.2" {
+		t.Errorf("Bad tag: '%v'. Expected: 'v1.2'", lib.Tag)
+	}
+	mirrorCmd := NewRunContext(mirror)
+	if err := mirrorCmd.Run("git", "rev-parse", "--verify", "refs/tags/v1.2"); err != nil {
+		t.Errorf("Mirror was not updated with the new tag")
+	}
+}
+
+func TestArchiveCache(t *testing.T) {
+	InitTestLogging()
+
+	data := gzipBytes(buildTestTar(testArchiveEntries))
+	sum256 := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum256[:])
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write(data)
+	}))
+	defer server.Close()
+	archiveUrl := server.URL + "/foo-1.0.tar.gz"
+
+	cacheRoot, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(cacheRoot)
+	cache := NewCache(cacheRoot)
+	libsrc := &ArchiveSCM{Cache: cache}
+
+	for _, item := range []struct {
+		Sha256   string
+		Corrupt  bool
+		Requests int
+	}{
+		{"", false, 1},     // nothing cached yet
+		{"", false, 2},     // no digest to check the cached copy against
+		{digest, false, 2}, // cached copy matches the digest
+		{digest, true, 3},  // cached copy is damaged, and replaced
+		{digest, false, 3},
+	} {
+		if item.Corrupt {
+			ioutil.WriteFile(cache.ArchivePath(archiveUrl), []byte("garbage"), 0644)
+		}
+		dep, _ := NewDependency("foo/bar/baz", archiveUrl, "1.0")
+		dep.StripComponents = 1
+		dep.Sha256 = item.Sha256
+		lib, err := libsrc.Resolve(dep)
+		if err != nil {
+			t.Errorf("%v", err)
+			continue
+		}
+		if !Exists(path.Join(lib.TempDir, "README")) {
+			t.Errorf("Missing 'README' from extracted archive")
+		}
+		os.RemoveAll(lib.TempDir)
+		if requests != item.Requests {
+			t.Errorf("Made %v requests, expected %v", requests, item.Requests)
+		}
+		if lib.Sha256 != digest {
+			t.Errorf("Bad sha256: '%v'. Expected: '%v'", lib.Sha256, digest)
+		}
+	}
+	if !Exists(cache.ArchivePath(archiveUrl)) {
+		t.Errorf("Archive was not stored in the cache")
+	}
+}
+
+func TestOfflineCache(t *testing.T) {
+	InitTestLogging()
+
+	basePath := BuildTestGitRepo("gitrepo")
+	defer os.RemoveAll(basePath)
+	repoUrl := "file://" + path.Join(basePath, "gitrepo")
+
+	data := gzipBytes(buildTestTar(testArchiveEntries))
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write(data)
+	}))
+	defer server.Close()
+	archiveUrl := server.URL + "/foo-1.0.tar.gz"
+
+	cacheRoot, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(cacheRoot)
+	cache := NewCache(cacheRoot)
+	cache.Offline = true
+
+	gitDep, _ := NewDependency("foo/bar/git", repoUrl, "1.0")
+	gitDep.Type = "git"
+	archiveDep, _ := NewDependency("foo/bar/archive", archiveUrl, "1.0")
+	archiveDep.Type = "archive"
+	archiveDep.StripComponents = 1
+	resolver := NewResolver()
+	resolver.LibSources["git"] = &GitSCM{Cache: cache}
+	resolver.LibSources["archive"] = &ArchiveSCM{Cache: cache}
+	resolver.LibSources["hg"] = &OfflineSCM{Cache: cache}
+	hgDep, _ := NewDependency("foo/bar/hg", "http://example.com/hgrepo", "")
+	hgDep.Type = "hg"
+	// without a url, each synthesized url is a candidate; a single miss is reported
+	synthDep, _ := NewDependency("example.com/synth", "", "")
+	synthDep.Type = "git"
+	synthHgDep, _ := NewDependency("example.com/synth-hg", "", "")
+	synthHgDep.Type = "hg"
+
+	// nothing is cached yet, and every miss is reported
+	deps := []*Dependency{gitDep, archiveDep, hgDep, synthDep, synthHgDep}
+	if _, err := resolver.ResolveDependencies(deps); err == nil {
+		t.Fatalf("Offline resolve succeeded with an empty cache")
+	}
+	expected := []string{
+		"archive '" + archiveUrl + "'",
+		"git mirror '" + repoUrl + "'",
+		"git mirror for import 'example.com/synth'",
+		"hg repository 'http://example.com/hgrepo'",
+		"hg repository for import 'example.com/synth-hg'",
+	}
+	if missing := cache.MissingEntries(); strings.Join(missing, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Bad missing entries: %v. Expected: %v", missing, expected)
+	}
+
+	// fill the cache, then resolve again without the network
+	cache.Offline = false
+	for _, dep := range []*Dependency{gitDep, archiveDep} {
+		if lib, err := resolver.Resolve(dep); err != nil {
+			t.Fatalf("%v", err)
+		} else {
+			os.RemoveAll(lib.TempDir)
+		}
+	}
+	cache.Offline = true
+	os.RemoveAll(basePath)
+	server.Close()
+	requests = 0
+	libs, err := resolver.ResolveDependencies([]*Dependency{gitDep, archiveDep})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, lib := range libs {
+		if !Exists(path.Join(lib.TempDir, "README")) {
+			t.Errorf("Missing 'README' from offline install of '%v'", lib.Import)
+		}
+		os.RemoveAll(lib.TempDir)
+	}
+	if requests != 0 {
+		t.Errorf("Made %v requests while offline", requests)
+	}
+}
+
+func TestOfflineSynthesizedUrl(t *testing.T) {
+	InitTestLogging()
+
+	basePath := BuildTestGitRepo("gitrepo")
+	defer os.RemoveAll(basePath)
+	cacheRoot, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(cacheRoot)
+	cache := NewCache(cacheRoot)
+	cache.Offline = true
+
+	// only the https url of the import has a mirror
+	mirror := cache.GitMirrorPath("https://example.com/synth")
+	cmd := NewRunContext(basePath)
+	cmd.MustRun("git", "clone", "--quiet", "--mirror", path.Join(basePath, "gitrepo"), mirror)
+
+	dep, _ := NewDependency("example.com/synth", "", "1.0")
+	libsrc := &GitSCM{Cache: cache}
+	lib, err := libsrc.Resolve(dep)
+	if err != nil {
+		t.Fatalf("Error resolving from the mirror of a synthesized url: %v", err)
+	}
+	defer os.RemoveAll(lib.TempDir)
+	if lib.Url.String() != "https://example.com/synth" {
+		t.Errorf("Resolved through '%v', expected the https url", lib.Url)
+	}
+	if missing := cache.MissingEntries(); len(missing) != 0 {
+		t.Errorf("Recorded misses for candidates that were not needed: %v", missing)
+	}
+}
+
+func TestCacheEntries(t *testing.T) {
+	InitTestLogging()
+
+	basePath := BuildTestGitRepo("gitrepo")
+	defer os.RemoveAll(basePath)
+	repoUrl := "file://" + path.Join(basePath, "gitrepo")
+
+	data := gzipBytes(buildTestTar(testArchiveEntries))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer server.Close()
+	archiveUrl := server.URL + "/foo-1.0.tar.gz"
+
+	cacheRoot, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(cacheRoot)
+	cache := NewCache(cacheRoot)
+	if _, err := cache.UpdateGitMirror(NewRunContext(cacheRoot), repoUrl); err != nil {
+		t.Fatalf("%v", err)
+	}
+	dep, _ := NewDependency("foo/bar/baz", archiveUrl, "1.0")
+	dep.StripComponents = 1
+	if lib, err := (&ArchiveSCM{Cache: cache}).Resolve(dep); err != nil {
+		t.Fatalf("%v", err)
+	} else {
+		os.RemoveAll(lib.TempDir)
+	}
+
+	entries, err := cache.Entries()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Bad entry count: %v. Expected: 2", len(entries))
+	}
+	for ii, expected := range []struct {
+		Kind string
+		Url  string
+	}{
+		{CacheGit, repoUrl},
+		{CacheArchives, archiveUrl},
+	} {
+		entry := entries[ii]
+		if entry.Kind != expected.Kind || entry.Url != expected.Url {
+			t.Errorf("Bad entry: %v '%v'. Expected: %v '%v'", entry.Kind, entry.Url, expected.Kind, expected.Url)
+		}
+		if entry.Size <= 0 {
+			t.Errorf("No size for entry: '%v'", entry.Url)
+		}
+		if time.Since(entry.LastUsed) > time.Minute {
+			t.Errorf("Bad last used time for entry '%v': %v", entry.Url, entry.LastUsed)
+		}
+		if err := entry.Verify(); err != nil {
+			t.Errorf("Entry '%v' failed verification: %v", entry.Url, err)
+		}
+	}
+
+	// damage both entries
+	ioutil.WriteFile(entries[1].Path, []byte("garbage"), 0644)
+	objects := path.Join(entries[0].Path, "objects")
+	filepath.Walk(objects, func(name string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && !strings.Contains(name, "info") {
+			os.Chmod(name, 0644)
+			ioutil.WriteFile(name, []byte("garbage"), 0644)
+		}
+		return nil
+	})
+	for _, entry := range entries {
+		if err := entry.Verify(); err == nil {
+			t.Errorf("Damaged entry '%v' passed verification", entry.Url)
+		}
+	}
+
+	// removal takes the description files along
+	if err := entries[1].Remove(); err != nil {
+		t.Errorf("%v", err)
+	}
+	for _, name := range []string{entries[1].Path, entries[1].Path + ".url", entries[1].Path + ".sha256"} {
+		if Exists(name) {
+			t.Errorf("'%v' was not removed", name)
+		}
+	}
+	if err := cache.Clear(); err != nil {
+		t.Errorf("%v", err)
+	}
+	if entries, _ := cache.Entries(); len(entries) != 0 {
+		t.Errorf("Cache not empty after Clear: %v entries", len(entries))
+	}
+}
+
+func TestCacheLock(t *testing.T) {
+	InitTestLogging()
+	cacheRoot, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(cacheRoot)
+
+	// separate caches on the same root stand in for separate processes
+	first := NewCache(cacheRoot)
+	second := NewCache(cacheRoot)
+	entry := first.ArchivePath("http://example.com/foo.tar.gz")
+
+	unlock := first.Lock(entry)
+	locked := make(chan bool)
+	go func() {
+		defer second.Lock(entry)()
+		locked <- true
+	}()
+	select {
+	case <-locked:
+		t.Errorf("Locked a cache entry that is already locked")
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Errorf("Cache entry stayed locked after unlocking")
+	}
+	if !Exists(entry + ".lock") {
+		t.Errorf("No lock file for cache entry")
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	for value, expected := range map[string]time.Duration{
+		"30d":  30 * 24 * time.Hour,
+		"2w":   14 * 24 * time.Hour,
+		"1.5d": 36 * time.Hour,
+		"12h":  12 * time.Hour,
+		"90m":  90 * time.Minute,
+	} {
+		if result, err := ParseAge(value); err != nil || result != expected {
+			t.Errorf("'%v' parsed to %v (%v), expected %v", value, result, err, expected)
+		}
+	}
+	for _, value := range []string{"", "30", "d", "-1d", "thirty days"} {
+		if _, err := ParseAge(value); err == nil {
+			t.Errorf("Bad age parsed okay: '%v'", value)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"fmt"
+	toml "github.com/pelletier/go-toml"
+)
+
+// Settings from a .grapnelrc file, other than rewrite rules
+type Config struct {
+	GoProxyUrl  string        // base url for the 'goproxy' LibSource
+	ReleaseApis []*ReleaseApi // release APIs for the 'release' LibSource
+	Keyring     string        // GnuPG home directory with keys trusted for 'verify'
+	Credentials Credentials   // per-host credentials for fetching dependencies
+	CachePath   string        // location of the download cache
+	StorePath   string        // location of the install store
+}
+
+func NewConfig() *Config {
+	return &Config{}
+}
+
+// Loads settings in a TOML file, specified by the filename argument.
+func LoadConfig(filename string) (*Config, error) {
+	tree, err := toml.LoadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+
+	config := NewConfig()
+	if value, ok := tree.GetDefault("goproxy.url", "").(string); !ok {
+		pos := tree.GetPosition("goproxy.url")
+		return nil, fmt.Errorf("%s %s: 'url' must be a string value", filename, pos.String())
+	} else {
+		config.GoProxyUrl = value
+	}
+
+	for _, item := range []struct {
+		Key  string
+		Name string
+		Ptr  *string
+	}{
+		{"verify.keyring", "keyring", &config.Keyring},
+		{"cache.path", "path", &config.CachePath},
+		{"store.path", "path", &config.StorePath},
+	} {
+		key, ptr := item.Key, item.Ptr
+		if value, ok := tree.GetDefault(key, "").(string); !ok {
+			pos := tree.GetPosition(key)
+			return nil, fmt.Errorf("%s %s: '%s' must be a string value", filename, pos.String(), item.Name)
+		} else if value != "" {
+			if *ptr, err = AbsolutePath(value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// each [[release]] section maps a host onto an API
+	if releaseTree, ok := tree.Get("release").([]*toml.TomlTree); ok {
+		for _, apiTree := range releaseTree {
+			api := &ReleaseApi{}
+			for key, ptr := range map[string]*string{
+				"host": &api.Host,
+				"api":  &api.Api,
+				"url":  &api.Url,
+			} {
+				value, ok := apiTree.GetDefault(key, "").(string)
+				if !ok || value == "" {
+					pos := apiTree.GetPosition("")
+					return nil, fmt.Errorf("%s %s: release '%s' must be a non-empty string value",
+						filename, pos.String(), key)
+				}
+				*ptr = value
+			}
+			if api.Api != ReleaseApiGitHub && api.Api != ReleaseApiGitLab {
+				pos := apiTree.GetPosition("api")
+				return nil, fmt.Errorf("%s %s: release 'api' must be '%s' or '%s'",
+					filename, pos.String(), ReleaseApiGitHub, ReleaseApiGitLab)
+			}
+			config.ReleaseApis = append(config.ReleaseApis, api)
+		}
+	} else if tree.Has("release") {
+		pos := tree.GetPosition("release")
+		return nil, fmt.Errorf("%s %s: 'release' must be an array of tables", filename, pos.String())
+	}
+
+	// each [[credentials]] section applies to a single host
+	if credTree, ok := tree.Get("credentials").([]*toml.TomlTree); ok {
+		for _, hostTree := range credTree {
+			cred := &Credential{}
+			for key, ptr := range map[string]*string{
+				"host":     &cred.Host,
+				"identity": &cred.Identity,
+				"username": &cred.Username,
+				"token":    &cred.Token,
+				"netrc":    &cred.Netrc,
+			} {
+				value, ok := hostTree.GetDefault(key, "").(string)
+				if !ok {
+					pos := hostTree.GetPosition(key)
+					return nil, fmt.Errorf("%s %s: credentials '%s' must be a string value",
+						filename, pos.String(), key)
+				}
+				*ptr = value
+			}
+			if cred.Host == "" {
+				pos := hostTree.GetPosition("")
+				return nil, fmt.Errorf("%s %s: credentials must have a 'host'", filename, pos.String())
+			}
+			for _, ptr := range []*string{&cred.Identity, &cred.Netrc} {
+				if *ptr != "" {
+					if *ptr, err = AbsolutePath(*ptr); err != nil {
+						return nil, err
+					}
+				}
+			}
+			config.Credentials = append(config.Credentials, cred)
+		}
+	} else if tree.Has("credentials") {
+		pos := tree.GetPosition("credentials")
+		return nil, fmt.Errorf("%s %s: 'credentials' must be an array of tables", filename, pos.String())
+	}
+	return config, nil
+}
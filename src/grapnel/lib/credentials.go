@@ -0,0 +1,175 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// Credentials for a single host, from a [[credentials]] section of .grapnelrc
+type Credential struct {
+	Host     string // host, with an optional port, that these apply to
+	Identity string // ssh private key file
+	Username string // user name for ssh and HTTP
+	Token    string // password or access token for HTTP
+	Netrc    string // netrc file to read the HTTP login and password from
+}
+
+type Credentials []*Credential
+
+// Finds the credentials for the host in 'rawUrl'.  An entry with a port
+// only matches that port, and wins over one without a port.
+func (self Credentials) Find(rawUrl string) *Credential {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil || parsed.Host == "" {
+		return nil
+	}
+	hostname := parsed.Host
+	if name, _, err := net.SplitHostPort(parsed.Host); err == nil {
+		hostname = name
+	}
+	for _, host := range []string{parsed.Host, hostname} {
+		for _, cred := range self {
+			if cred.Host == host {
+				return cred
+			}
+		}
+	}
+	return nil
+}
+
+// Looks up the login and password for 'host' in a netrc file.  A 'default'
+// entry is used when no machine matches.
+func readNetrc(filename, host string) (string, string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", "", fmt.Errorf("Cannot read netrc file: %v", err)
+	}
+	type entry struct{ login, password string }
+	var found, fallback *entry
+	var current *entry
+	tokens := strings.Fields(string(data))
+	for ii := 0; ii < len(tokens); ii++ {
+		switch tokens[ii] {
+		case "machine":
+			current = nil
+			if ii+1 < len(tokens) {
+				ii++
+				if tokens[ii] == host && found == nil {
+					found = &entry{}
+					current = found
+				}
+			}
+		case "default":
+			current = nil
+			if fallback == nil {
+				fallback = &entry{}
+				current = fallback
+			}
+		case "login", "password", "account":
+			key := tokens[ii]
+			if ii+1 >= len(tokens) {
+				break
+			}
+			ii++
+			if current == nil {
+				continue
+			} else if key == "login" {
+				current.login = tokens[ii]
+			} else if key == "password" {
+				current.password = tokens[ii]
+			}
+		}
+	}
+	if found == nil {
+		found = fallback
+	}
+	if found == nil {
+		return "", "", nil
+	}
+	return found.login, found.password, nil
+}
+
+// Returns the value of an Authorization header for HTTP requests, or "" if
+// there is nothing to send.  A token without a user name is sent as a bearer
+// token; anything else uses basic authentication.
+func (self *Credential) AuthHeader() (string, error) {
+	username, password := self.Username, self.Token
+	if password == "" && self.Netrc != "" {
+		hostname := self.Host
+		if name, _, err := net.SplitHostPort(self.Host); err == nil {
+			hostname = name
+		}
+		login, netrcPassword, err := readNetrc(self.Netrc, hostname)
+		if err != nil {
+			return "", err
+		}
+		if username == "" {
+			username = login
+		}
+		password = netrcPassword
+	}
+	if password == "" {
+		return "", nil
+	} else if username == "" {
+		return "Bearer " + password, nil
+	}
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password)), nil
+}
+
+// Quotes 'value' for use in a shell command.
+func shellQuote(value string) string {
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
+
+// Returns environment variables that make git use these credentials: the
+// identity file through GIT_SSH_COMMAND, and the Authorization header for
+// HTTP urls on this host only.
+func (self *Credential) GitEnv() ([]string, error) {
+	env := []string{}
+	if self.Identity != "" {
+		sshCommand := "ssh -i " + shellQuote(self.Identity) + " -o IdentitiesOnly=yes"
+		if self.Username != "" {
+			sshCommand += " -l " + shellQuote(self.Username)
+		}
+		env = append(env, "GIT_SSH_COMMAND="+sshCommand)
+	}
+	header, err := self.AuthHeader()
+	if err != nil {
+		return nil, err
+	}
+	if header != "" {
+		env = append(env, "GIT_CONFIG_COUNT=2")
+		for ii, scheme := range []string{"https", "http"} {
+			env = append(env,
+				fmt.Sprintf("GIT_CONFIG_KEY_%d=http.%s://%s/.extraHeader", ii, scheme, self.Host),
+				fmt.Sprintf("GIT_CONFIG_VALUE_%d=Authorization: %s", ii, header))
+		}
+	}
+	return env, nil
+}
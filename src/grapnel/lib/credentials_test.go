@@ -0,0 +1,161 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCredentialsFind(t *testing.T) {
+	creds := Credentials{
+		{Host: "git.example.com", Username: "any"},
+		{Host: "git.example.com:8443", Username: "port"},
+		{Host: "other.example.com", Username: "other"},
+	}
+	for rawUrl, expected := range map[string]string{
+		"https://git.example.com/foo/bar":      "any",
+		"ssh://git@git.example.com:22/foo/bar": "any",
+		"https://git.example.com:8443/foo":     "port",
+		"http://other.example.com":             "other",
+		"https://example.com/foo":              "",
+		"not a url":                            "",
+	} {
+		result := ""
+		if cred := creds.Find(rawUrl); cred != nil {
+			result = cred.Username
+		}
+		if result != expected {
+			t.Errorf("'%v' found credentials '%v', expected '%v'", rawUrl, result, expected)
+		}
+	}
+}
+
+func TestCredentialAuthHeader(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	netrc := filepath.Join(tempDir, "netrc")
+	ioutil.WriteFile(netrc, []byte(`
+machine other.example.com login nobody password wrong
+machine git.example.com
+  login alice
+  password s3cret
+default login anonymous password guest
+`), 0600)
+
+	for _, test := range []struct {
+		Cred     *Credential
+		Expected string
+	}{
+		{&Credential{Host: "git.example.com"}, ""},
+		{&Credential{Host: "git.example.com", Token: "abc"}, "Bearer abc"},
+		{&Credential{Host: "git.example.com", Username: "bob", Token: "abc"}, "Basic Ym9iOmFiYw=="},
+		{&Credential{Host: "git.example.com", Netrc: netrc}, "Basic YWxpY2U6czNjcmV0"},
+		{&Credential{Host: "git.example.com:8443", Netrc: netrc}, "Basic YWxpY2U6czNjcmV0"},
+		{&Credential{Host: "unlisted.example.com", Netrc: netrc}, "Basic YW5vbnltb3VzOmd1ZXN0"},
+		{&Credential{Host: "git.example.com", Token: "abc", Netrc: netrc}, "Bearer abc"},
+	} {
+		if header, err := test.Cred.AuthHeader(); err != nil {
+			t.Errorf("Error building header for %+v: %v", *test.Cred, err)
+		} else if header != test.Expected {
+			t.Errorf("Bad header for %+v: '%v'. Expected: '%v'", *test.Cred, header, test.Expected)
+		}
+	}
+
+	// negative test
+	cred := &Credential{Host: "git.example.com", Netrc: filepath.Join(tempDir, "missing")}
+	if _, err := cred.AuthHeader(); err == nil {
+		t.Errorf("Missing netrc file read okay")
+	}
+}
+
+func TestCredentialGitEnv(t *testing.T) {
+	cred := &Credential{
+		Host:     "git.example.com",
+		Identity: "/home/o'brien/.ssh/deploy",
+		Username: "deploy",
+		Token:    "abc",
+	}
+	env, err := cred.GitEnv()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	expected := []string{
+		`GIT_SSH_COMMAND=ssh -i '/home/o'\''brien/.ssh/deploy' -o IdentitiesOnly=yes -l 'deploy'`,
+		"GIT_CONFIG_COUNT=2",
+		"GIT_CONFIG_KEY_0=http.https://git.example.com/.extraHeader",
+		"GIT_CONFIG_VALUE_0=Authorization: Basic ZGVwbG95OmFiYw==",
+		"GIT_CONFIG_KEY_1=http.http://git.example.com/.extraHeader",
+		"GIT_CONFIG_VALUE_1=Authorization: Basic ZGVwbG95OmFiYw==",
+	}
+	if strings.Join(env, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Bad git environment: %v\nExpected: %v", env, expected)
+	}
+
+	if env, err := (&Credential{Host: "git.example.com"}).GitEnv(); err != nil || len(env) != 0 {
+		t.Errorf("Empty credentials produced a git environment: %v %v", env, err)
+	}
+}
+
+func TestArchiveCredentials(t *testing.T) {
+	data := gzipBytes(buildTestTar(testArchiveEntries))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer abc" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Write(data)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	for _, test := range []struct {
+		Creds Credentials
+		Valid bool
+	}{
+		{Credentials{{Host: host, Token: "abc"}}, true},
+		{Credentials{{Host: host, Token: "wrong"}}, false},
+		{Credentials{{Host: "example.com", Token: "abc"}}, false},
+		{nil, false},
+	} {
+		dep, _ := NewDependency("foo/bar/baz", server.URL+"/foo.tar.gz", "")
+		libsrc := &ArchiveSCM{Credentials: test.Creds}
+		lib, err := libsrc.Resolve(dep)
+		if lib != nil {
+			defer os.RemoveAll(lib.TempDir)
+		}
+		if test.Valid && err != nil {
+			t.Errorf("Error downloading with credentials: %v", err)
+		} else if !test.Valid && err == nil {
+			t.Errorf("Downloaded okay with credentials: %+v", test.Creds)
+		}
+	}
+}
@@ -26,6 +26,11 @@ import (
 	"fmt"
 	toml "github.com/pelletier/go-toml"
 	url "grapnel/url"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
 )
 
 type Dependency struct {
@@ -34,14 +39,64 @@ type Dependency struct {
 	Url         *url.URL
 	Type        string
 	Branch      string
-	Tag         string // alased to: commit and revision
+	Tag         string    // alased to: commit and revision
+	Before      time.Time // pins to the last commit at or before this time
 	VersionSpec *VersionSpec
+	Strategy    int    // version selection strategy; see ParseStrategy
+	Subdir      string // part of the repository that provides the import
+
+	StripComponents int    // leading path components to drop from archive entries
+	Sha256          string // expected hex digest of a downloaded archive
+	Sha512          string // expected hex digest of a downloaded archive
+	TreeHash        string // expected TreeHash of the installed files
+	UrlTemplate     string // archive url, with a '{{.version}}' placeholder
+	Index           string // listing of available archive versions
+	Asset           string // name, or glob, of a release asset to download
+
+	Verify           int               // VerifyNone, VerifySignedTag or VerifySignedCommit
+	Submodules       int               // SubmodulesNone, SubmodulesTop or SubmodulesRecursive
+	SubmoduleCommits map[string]string // pinned commit for each submodule path
+
+	// where the dependency came from in the graph; not part of its identity
+	RequestedImport  string   // import that was asked for, before rewrite rules
+	RequestedVersion string   // version spec as it was written
+	RequiredBy       []string // imports of the libraries that depend on this one
+}
+
+// Signature checks for git dependencies
+const (
+	VerifyNone = iota
+	VerifySignedTag
+	VerifySignedCommit
+)
+
+var verifyNames = map[int]string{
+	VerifyNone:         "",
+	VerifySignedTag:    "signed-tag",
+	VerifySignedCommit: "signed-commit",
+}
+
+func ParseVerify(src string) (int, error) {
+	for value, name := range verifyNames {
+		if name == src {
+			return value, nil
+		}
+	}
+	return VerifyNone, fmt.Errorf("'verify' must be \"signed-tag\" or \"signed-commit\", not: '%s'", src)
 }
 
+// Ways to treat git submodules
+const (
+	SubmodulesNone = iota
+	SubmodulesTop
+	SubmodulesRecursive
+)
+
 func NewDependency(importStr string, urlStr string, versionStr string) (*Dependency, error) {
 	var err error
 	dep := &Dependency{
-		Import: importStr,
+		Import:           importStr,
+		RequestedVersion: versionStr,
 	}
 
 	if urlStr == "" {
@@ -75,6 +130,7 @@ func (self *Dependency) Flatten() map[string]string {
 	results["type"] = self.Type
 	results["branch"] = self.Branch
 	results["tag"] = self.Tag
+	results["url_template"] = self.UrlTemplate
 	if self.Url != nil {
 		results["scheme"] = self.Url.Scheme
 		results["host"] = self.Url.Host
@@ -143,11 +199,32 @@ func (self *Dependency) SetValues(valueMap map[string]string) error {
 	return nil
 }
 
+// Adds to the imports of the libraries that depend on this one, keeping them
+// sorted and unique.  A library never depends on itself.
+func (self *Dependency) AddRequiredBy(imports ...string) {
+	for _, name := range imports {
+		idx := sort.SearchStrings(self.RequiredBy, name)
+		if name == self.Import || (idx < len(self.RequiredBy) && self.RequiredBy[idx] == name) {
+			continue
+		}
+		// build a new slice; copies of this dependency may share the old one
+		next := make([]string, 0, len(self.RequiredBy)+1)
+		next = append(next, self.RequiredBy[:idx]...)
+		next = append(next, name)
+		self.RequiredBy = append(next, self.RequiredBy[idx:]...)
+	}
+}
+
 func (self *Dependency) Reconcile(other *Dependency) (*Dependency, error) {
 	if self.VersionSpec.Outranks(other.VersionSpec) {
 		return self, nil
 	} else if other.VersionSpec.Outranks(self.VersionSpec) {
 		return other, nil
+	} else if spec := self.VersionSpec.Intersect(other.VersionSpec); spec != nil {
+		// neither spec covers the other; only versions that satisfy both will do
+		result := *self
+		result.VersionSpec = spec
+		return &result, nil
 	}
 	return nil, fmt.Errorf("Cannot reconcile dependencies for '%v'", self.Import)
 }
@@ -157,6 +234,18 @@ func (self *Dependency) Equal(other *Dependency) bool {
 		self.Type == other.Type &&
 		self.Branch == other.Branch &&
 		self.Tag == other.Tag &&
+		self.Before.Equal(other.Before) &&
+		self.Subdir == other.Subdir &&
+		self.StripComponents == other.StripComponents &&
+		self.Sha256 == other.Sha256 &&
+		self.Sha512 == other.Sha512 &&
+		self.TreeHash == other.TreeHash &&
+		self.UrlTemplate == other.UrlTemplate &&
+		self.Index == other.Index &&
+		self.Asset == other.Asset &&
+		self.Verify == other.Verify &&
+		self.Submodules == other.Submodules &&
+		reflect.DeepEqual(self.SubmoduleCommits, other.SubmoduleCommits) &&
 		self.VersionSpec == other.VersionSpec {
 		if self.Url != nil && other.Url != nil {
 			return self.Url.Equal(other.Url)
@@ -182,10 +271,118 @@ func NewDependencyFromToml(tree *toml.TomlTree) (*Dependency, error) {
 	dep.Type = tree.GetDefault("type", "").(string)
 	dep.Branch = tree.GetDefault("branch", "").(string)
 	dep.Tag = tree.GetDefault("tag", "").(string)
+	dep.Sha256 = tree.GetDefault("sha256", "").(string)
+	dep.Sha512 = tree.GetDefault("sha512", "").(string)
+	dep.TreeHash = tree.GetDefault("tree_hash", "").(string)
+	dep.RequestedImport = tree.GetDefault("requested_import", "").(string)
+	if tree.Has("requested_version") {
+		dep.RequestedVersion = tree.GetDefault("requested_version", "").(string)
+	}
+	dep.UrlTemplate = tree.GetDefault("url_template", "").(string)
+	dep.Index = tree.GetDefault("index", "").(string)
+	dep.Asset = tree.GetDefault("asset", "").(string)
+
+	if dep.Subdir, err = CleanSubdir(tree.GetDefault("subdir", "").(string)); err != nil {
+		return nil, err
+	}
+
+	if strip, ok := tree.GetDefault("strip_components", int64(0)).(int64); !ok || strip < 0 {
+		return nil, fmt.Errorf("'strip_components' must be a non-negative integer")
+	} else {
+		dep.StripComponents = int(strip)
+	}
+
+	if dep.Verify, err = ParseVerify(tree.GetDefault("verify", "").(string)); err != nil {
+		return nil, err
+	}
+
+	switch submodules := tree.GetDefault("submodules", false).(type) {
+	case bool:
+		if submodules {
+			dep.Submodules = SubmodulesTop
+		}
+	case string:
+		if submodules != "recursive" {
+			return nil, fmt.Errorf("'submodules' must be true, false or \"recursive\"")
+		}
+		dep.Submodules = SubmodulesRecursive
+	default:
+		return nil, fmt.Errorf("'submodules' must be true, false or \"recursive\"")
+	}
+
+	if commits, ok := tree.GetDefault("submodule_commits", []interface{}{}).([]interface{}); !ok {
+		return nil, fmt.Errorf("'submodule_commits' must be an array of strings")
+	} else {
+		for _, item := range commits {
+			pin, _ := item.(string)
+			parts := strings.SplitN(pin, "=", 2)
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				return nil, fmt.Errorf("'submodule_commits' entries must look like 'path=commit'")
+			}
+			if dep.SubmoduleCommits == nil {
+				dep.SubmoduleCommits = map[string]string{}
+			}
+			dep.SubmoduleCommits[parts[0]] = parts[1]
+		}
+	}
+
+	if requiredBy, ok := tree.GetDefault("required_by", []interface{}{}).([]interface{}); !ok {
+		return nil, fmt.Errorf("'required_by' must be an array of strings")
+	} else {
+		for _, item := range requiredBy {
+			name, ok := item.(string)
+			if !ok || name == "" {
+				return nil, fmt.Errorf("'required_by' must be an array of strings")
+			}
+			dep.AddRequiredBy(name)
+		}
+	}
+
+	switch before := tree.GetDefault("before", "").(type) {
+	case time.Time:
+		dep.Before = before
+	case string:
+		if dep.Before, err = ParseBeforeDate(before); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("'before' must be a date or string value")
+	}
 
 	return dep, nil
 }
 
+// Normalizes a 'subdir' value, which must stay within the repository.
+func CleanSubdir(subdir string) (string, error) {
+	if subdir == "" {
+		return "", nil
+	}
+	cleaned := path.Clean(subdir)
+	if path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("'subdir' must be a path within the repository: '%s'", subdir)
+	}
+	if cleaned == "." {
+		return "", nil
+	}
+	return cleaned, nil
+}
+
+// Parses a 'before' cutoff as either a full RFC3339 timestamp, or a plain
+// YYYY-MM-DD date.  A plain date includes the entire day, in UTC.
+func ParseBeforeDate(src string) (time.Time, error) {
+	if src == "" {
+		return time.Time{}, nil
+	}
+	if value, err := time.Parse(time.RFC3339, src); err == nil {
+		return value, nil
+	}
+	value, err := time.Parse("2006-01-02", src)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Cannot parse 'before' date: '%s'", src)
+	}
+	return value.Add(24*time.Hour - time.Second), nil
+}
+
 func loadDependencies(filename string) ([]*Dependency, error) {
 	tree, err := toml.LoadFile(filename)
 	if err != nil {
@@ -209,6 +406,20 @@ func loadDependencies(filename string) ([]*Dependency, error) {
 	return deplist, nil
 }
 
+// Loads the version selection strategy from the [package] section of a
+// grapnel file.  Returns StrategyNewest if none is specified.
+func LoadPackageStrategy(filename string) (int, error) {
+	tree, err := toml.LoadFile(filename)
+	if err != nil {
+		return StrategyNewest, fmt.Errorf("%s %s", filename, err)
+	}
+	value, ok := tree.GetDefault("package.strategy", "").(string)
+	if !ok {
+		return StrategyNewest, fmt.Errorf("%s: 'strategy' must be a string value", filename)
+	}
+	return ParseStrategy(value)
+}
+
 func LoadGrapnelDepsfile(searchFiles ...string) ([]*Dependency, error) {
 	for _, filename := range searchFiles {
 		if Exists(filename) {
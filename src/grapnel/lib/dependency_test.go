@@ -25,6 +25,7 @@ THE SOFTWARE.
 import (
 	toml "github.com/pelletier/go-toml"
 	"testing"
+	"time"
 )
 
 var testDependencyEntry = `
@@ -94,3 +95,72 @@ func TestNewDependencyFromToml(t *testing.T) {
 			dep.VersionSpec.String(), "1.0.*")
 	}
 }
+
+func TestDependencyBeforeFromToml(t *testing.T) {
+	for _, item := range []struct {
+		Entry  string
+		Result string
+	}{
+		{`before = "2015-01-24"`, "2015-01-24T23:59:59Z"},
+		{`before = "2015-01-24T08:00:00-05:00"`, "2015-01-24T13:00:00Z"},
+		{`before = 2015-01-24T12:00:00Z`, "2015-01-24T12:00:00Z"},
+	} {
+		tree, err := toml.Load("import = \"foo/bar/baz\"\n" + item.Entry)
+		if err != nil {
+			t.Errorf("Error parsing TOML data: %v", err)
+			continue
+		}
+		dep, err := NewDependencyFromToml(tree)
+		if err != nil {
+			t.Errorf("Error building dependency from TOML: %v", err)
+			continue
+		}
+		if result := dep.Before.UTC().Format(time.RFC3339); result != item.Result {
+			t.Errorf("Bad value for before: '%v'. Expected: '%v'", result, item.Result)
+		}
+	}
+
+	// negative test
+	tree, _ := toml.Load("import = \"foo/bar/baz\"\nbefore = \"last tuesday\"")
+	if _, err := NewDependencyFromToml(tree); err == nil {
+		t.Errorf("Bad 'before' date parsed okay")
+	}
+}
+
+func TestCleanSubdir(t *testing.T) {
+	for value, expected := range map[string]string{
+		"":               "",
+		".":              "",
+		"tools/lib/foo":  "tools/lib/foo",
+		"tools/lib/foo/": "tools/lib/foo",
+		"./tools/../lib": "lib",
+	} {
+		if result, err := CleanSubdir(value); err != nil {
+			t.Errorf("Error cleaning '%v': %v", value, err)
+		} else if result != expected {
+			t.Errorf("'%v' cleaned to '%v', expected '%v'", value, result, expected)
+		}
+	}
+
+	// negative tests
+	for _, value := range []string{"/etc", "..", "../foo", "tools/../../foo"} {
+		if _, err := CleanSubdir(value); err == nil {
+			t.Errorf("Subdir outside of the repository cleaned okay: '%v'", value)
+		}
+	}
+}
+
+func TestParseVerify(t *testing.T) {
+	for name, expected := range map[string]int{
+		"":              VerifyNone,
+		"signed-tag":    VerifySignedTag,
+		"signed-commit": VerifySignedCommit,
+	} {
+		if result, err := ParseVerify(name); err != nil || result != expected {
+			t.Errorf("'%v' parsed to %v (%v), expected %v", name, result, err, expected)
+		}
+	}
+	if _, err := ParseVerify("signed"); err == nil {
+		t.Errorf("Bad verify mode parsed okay")
+	}
+}
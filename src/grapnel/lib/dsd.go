@@ -0,0 +1,141 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Preamble for dead-simple downloader scripts.  Each library is fetched into
+// its own '$dir', checked against what the lockfile pinned, and then moved
+// into place by 'install_lib'.
+const dsdHeader = `#!/bin/bash
+# Grapnel Dead-simple Downloader
+#
+# Reproduces the libraries pinned in a grapnel lockfile, without grapnel.
+# Usage: grapnel-dsd.sh [target]    (the default target is ./src)
+#
+# The script holds no credentials.  Archives from hosts that need them are
+# downloaded with the login in ~/.netrc; version control tools use their own
+# credential helpers.
+set -euo pipefail
+
+target="${1:-src}"
+work="$(mktemp -d)"
+trap 'rm -rf "$work"' EXIT
+
+fail() {
+	echo "grapnel-dsd: $*" >&2
+	exit 1
+}
+
+# fails unless the expected value, $2, matches the actual value, $3
+check_equal() {
+	[ "$2" = "$3" ] || fail "$1 mismatch: expected '$2', got '$3'"
+}
+
+download() {
+	if command -v curl >/dev/null; then
+		curl -fsSL --netrc-optional -o "$2" "$1" || fail "cannot download: $1"
+	else
+		wget -q -O "$2" "$1" || fail "cannot download: $1"
+	fi
+}
+
+# checks file $3 against the hex digest $2, using algorithm $1 (sha256 or sha512)
+check_digest() {
+	local actual
+	if command -v "${1}sum" >/dev/null; then
+		actual="$("${1}sum" "$3" | cut -d' ' -f1)"
+	else
+		actual="$(shasum -a "${1#sha}" "$3" | cut -d' ' -f1)"
+	fi
+	check_equal "$1 of $3" "$(echo "$2" | tr 'A-F' 'a-f')" "$actual"
+}
+
+# extracts archive $1 into $2, dropping $3 leading path components
+extract() {
+	local archive="$1" dest="$2" strip="$3" root i
+	if [ "$(head -c 2 "$archive")" = "PK" ]; then
+		root="$dest.unzip"
+		unzip -q "$archive" -d "$root" || fail "cannot extract: $archive"
+		for ((i = 0; i < strip; i++)); do
+			set -- "$root"/*
+			[ "$#" -eq 1 ] && [ -d "$1" ] || fail "cannot strip $strip components from: $archive"
+			root="$1"
+		done
+		cp -R "$root"/. "$dest"
+	else
+		tar -xf "$archive" -C "$dest" --strip-components="$strip" || fail "cannot extract: $archive"
+	fi
+}
+
+strip_git() {
+	find "$1" -name .git -prune -exec rm -rf {} +
+}
+
+# moves $1, or its subdirectory $3, to the import path $2 under the target
+install_lib() {
+	local dest="$target/$2"
+	rm -rf "$dest"
+	mkdir -p "$(dirname "$dest")"
+	mv "$1/$3" "$dest" || fail "cannot install: $2"
+	# directories from mktemp are private to the user
+	chmod 0755 "$dest"
+	echo "grapnel-dsd: installed $2"
+}
+`
+
+// Joins the arguments into a shell command.  Arguments that start with '$'
+// are expanded by the shell, but are quoted against word splitting.
+func dsdCommand(args ...string) string {
+	quoted := []string{}
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "$") {
+			quoted = append(quoted, `"`+arg+`"`)
+		} else {
+			quoted = append(quoted, shellQuote(arg))
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+// Joins the lines of a script snippet.
+func dsdLines(lines ...string) string {
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// Returns the DSD commands that download and check the archive fetched for
+// 'lib', and extract it into '$dir'.
+func archiveDSD(lib *Library) string {
+	lines := []string{dsdCommand("download", lib.ArchiveUrl, "$dir.archive")}
+	if lib.Sha256 != "" {
+		lines = append(lines, dsdCommand("check_digest", "sha256", lib.Sha256, "$dir.archive"))
+	}
+	if lib.Sha512 != "" {
+		lines = append(lines, dsdCommand("check_digest", "sha512", lib.Sha512, "$dir.archive"))
+	}
+	lines = append(lines, dsdCommand("extract", "$dir.archive", "$dir", fmt.Sprint(lib.ArchiveStrip)))
+	return dsdLines(lines...)
+}
@@ -0,0 +1,201 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	. "grapnel/testing"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestResolverToDsd(t *testing.T) {
+	InitTestLogging()
+
+	basePath := BuildTestGitRepo("gitrepo")
+	defer os.RemoveAll(basePath)
+	repoUrl := "file://" + path.Join(basePath, "gitrepo")
+
+	zipData := buildTestZip(testArchiveEntries)
+	tarData := gzipBytes(buildTestTar(testArchiveEntries))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/foo-1.0.zip":
+			w.Write(zipData)
+		case "/foo-1.0.tar.gz":
+			w.Write(tarData)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	resolver := NewResolver()
+	resolver.LibSources["git"] = &GitSCM{}
+	resolver.LibSources["archive"] = &ArchiveSCM{}
+
+	// resolve a mix of libraries, as 'update' would
+	libs := []*Library{}
+	for _, item := range []struct {
+		Import string
+		Url    string
+		Type   string
+		Subdir string
+	}{
+		{"example.com/git", repoUrl, "git", ""},
+		{"example.com/zip", server.URL + "/foo-1.0.zip", "archive", ""},
+		{"example.com/tar", server.URL + "/foo-1.0.tar.gz", "archive", "bar"},
+	} {
+		dep, err := NewDependency(item.Import, item.Url, "1.0")
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		dep.Type = item.Type
+		dep.Subdir = item.Subdir
+		dep.StripComponents = 1
+		lib, err := resolver.LibSources[item.Type].Resolve(dep)
+		if err != nil {
+			t.Fatalf("Error resolving '%v': %v", item.Import, err)
+		}
+		defer os.RemoveAll(lib.TempDir)
+		libs = append(libs, lib)
+	}
+
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	dsdFile := path.Join(tempDir, "grapnel-dsd.sh")
+	target := path.Join(tempDir, "src")
+	if err := resolver.ToDsd(dsdFile, libs); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// the script reproduces the libraries on its own
+	cmd := NewRunContext(tempDir)
+	if err := cmd.Run(dsdFile, target); err != nil {
+		t.Fatalf("Error running dsd script: %v\n%v", err, cmd.CombinedOutput)
+	}
+	for _, name := range []string{
+		"example.com/git/README",
+		"example.com/zip/README",
+		"example.com/zip/bar/bar.go",
+		"example.com/tar/bar.go",
+	} {
+		if !Exists(path.Join(target, name)) {
+			t.Errorf("dsd script did not install '%v'", name)
+		}
+	}
+	for _, name := range []string{
+		"example.com/git/.git",
+		"example.com/git/foo.txt", // only in v1.1
+		"example.com/tar/README",
+	} {
+		if Exists(path.Join(target, name)) {
+			t.Errorf("dsd script should not have installed '%v'", name)
+		}
+	}
+	for _, name := range []string{"example.com/git", "example.com/zip", "example.com/tar"} {
+		if info, err := os.Stat(path.Join(target, name)); err != nil || info.Mode().Perm() != 0755 {
+			t.Errorf("dsd script installed '%v' with the wrong mode", name)
+		}
+	}
+
+	// negative tests: the script fails loudly on a mismatch
+	for idx, tamper := range []func(){
+		func() { libs[0].Commit = strings.Repeat("0", 40) },
+		func() { libs[1].Sha256 = strings.Repeat("0", 64) },
+	} {
+		saved := []Library{*libs[0], *libs[1]}
+		tamper()
+		if err := resolver.ToDsd(dsdFile, libs); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if err := cmd.Run(dsdFile, target); err == nil {
+			t.Errorf("Tampered dsd script #%v ran okay", idx)
+		} else if !strings.Contains(cmd.CombinedOutput, "mismatch") {
+			t.Errorf("Tampered dsd script #%v failed for the wrong reason: %v", idx, cmd.CombinedOutput)
+		}
+		*libs[0], *libs[1] = saved[0], saved[1]
+	}
+}
+
+func TestVcsToDsd(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// stand-ins for svn and bzr, which report the revision in $FAKE_REVISION
+	binDir := path.Join(tempDir, "bin")
+	os.Mkdir(binDir, 0755)
+	for name, script := range map[string]string{
+		"svn": "case \"$1\" in\n" +
+			"info) echo \"Last Changed Rev: $FAKE_REVISION\" ;;\n" +
+			"export) mkdir -p \"${!#}\" ;;\n" +
+			"esac\n",
+		"bzr": "case \"$1\" in\n" +
+			"branch) mkdir -p \"${!#}/.bzr\" ;;\n" +
+			"revision-info) echo \"12 $FAKE_REVISION\" ;;\n" +
+			"esac\n",
+	} {
+		ioutil.WriteFile(path.Join(binDir, name), []byte("#!/bin/bash\n"+script), 0755)
+	}
+
+	dep, _ := NewDependency("example.com/foo", "http://example.com/foo", "")
+	lib := NewLibrary(dep)
+	for _, item := range []struct {
+		Source   LibSource
+		Tag      string
+		Pin      string
+		Revision string
+		Valid    bool
+	}{
+		{&SvnSCM{}, "42", "'-r' '42'", "42", true},
+		{&SvnSCM{}, "42", "'-r' '42'", "43", false},
+		{&BzrSCM{}, "revid:me@example.com-1", "'--revision' 'revid:me@example.com-1'", "me@example.com-1", true},
+		{&BzrSCM{}, "revid:me@example.com-1", "'--revision' 'revid:me@example.com-1'", "me@example.com-2", false},
+	} {
+		lib.Branch = "trunk"
+		lib.Tag = item.Tag
+		fetch := item.Source.ToDSD(lib)
+		if !strings.Contains(fetch, item.Pin) {
+			t.Errorf("dsd entry is not pinned with '%v':\n%v", item.Pin, fetch)
+		}
+		script := path.Join(tempDir, "fetch.sh")
+		ioutil.WriteFile(script, []byte(dsdHeader+"dir=\"$work/lib\"\n"+fetch), 0755)
+		cmd := NewRunContext(tempDir)
+		cmd.Env = []string{"PATH=" + binDir + ":" + os.Getenv("PATH"), "FAKE_REVISION=" + item.Revision}
+		if err := cmd.Run(script); item.Valid && err != nil {
+			t.Errorf("Error running dsd entry for revision %v: %v", item.Revision, cmd.CombinedOutput)
+		} else if !item.Valid && (err == nil || !strings.Contains(cmd.CombinedOutput, "mismatch")) {
+			t.Errorf("dsd entry for '%v' accepted revision %v: %v", item.Tag, item.Revision, cmd.CombinedOutput)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Archive formats, as detected by ArchiveFormat
+const (
+	FormatUnknown = iota
+	FormatZip
+	FormatTar
+	FormatGzip
+	FormatBzip2
+	FormatXz
+)
+
+// Identifies an archive format from the leading bytes of a file.
+func ArchiveFormat(header []byte) int {
+	switch {
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")),
+		bytes.HasPrefix(header, []byte("PK\x05\x06")):
+		return FormatZip
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		return FormatGzip
+	case bytes.HasPrefix(header, []byte("BZh")):
+		return FormatBzip2
+	case bytes.HasPrefix(header, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}):
+		return FormatXz
+	case len(header) >= 262 && string(header[257:262]) == "ustar":
+		return FormatTar
+	}
+	return FormatUnknown
+}
+
+// Strips known archive extensions from a filename.
+func TrimArchiveExt(filename string) string {
+	for _, ext := range []string{".tar.gz", ".tar.bz2", ".tar.xz",
+		".tgz", ".tbz2", ".txz", ".zip", ".tar"} {
+		if strings.HasSuffix(filename, ext) {
+			return strings.TrimSuffix(filename, ext)
+		}
+	}
+	return filename
+}
+
+// Maps an archive entry name onto a path under 'dest', after dropping
+// 'strip' leading path components.  Returns an empty path if the entry is
+// stripped away entirely, and an error if the entry would escape 'dest'.
+func archiveEntryPath(dest, name string, strip int) (string, error) {
+	parts := strings.Split(strings.Trim(filepath.ToSlash(name), "/"), "/")
+	if len(parts) <= strip {
+		return "", nil
+	}
+	relativePath := filepath.Clean(filepath.FromSlash(strings.Join(parts[strip:], "/")))
+	if filepath.IsAbs(name) || relativePath == ".." ||
+		strings.HasPrefix(relativePath, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("Archive entry escapes target directory: '%s'", name)
+	}
+	return filepath.Join(dest, relativePath), nil
+}
+
+// Reports if 'name', a path relative to 'dest', stays within 'dest' once the
+// links already extracted there are followed.  Parts of the path that don't
+// exist yet are taken as they are.
+func staysWithin(dest, name string) bool {
+	pending := strings.Split(filepath.ToSlash(name), "/")
+	resolved := []string{}
+	for links := 0; len(pending) > 0; {
+		part := pending[0]
+		pending = pending[1:]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if len(resolved) == 0 {
+				return false
+			}
+			resolved = resolved[:len(resolved)-1]
+			continue
+		}
+
+		current := filepath.Join(dest, filepath.Join(resolved...), part)
+		info, err := os.Lstat(current)
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			resolved = append(resolved, part)
+			continue
+		}
+
+		// follow the link, as the filesystem would
+		if links++; links > 255 {
+			return false
+		}
+		target, err := os.Readlink(current)
+		if err != nil {
+			return false
+		}
+		if filepath.IsAbs(target) {
+			rel, err := filepath.Rel(dest, target)
+			if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return false
+			}
+			target, resolved = rel, []string{}
+		}
+		pending = append(strings.Split(filepath.ToSlash(target), "/"), pending...)
+	}
+	return true
+}
+
+// Returns an error if writing 'destPath' would leave 'dest', by way of links
+// extracted earlier.
+func checkArchivePath(dest, destPath, name string) error {
+	rel, err := filepath.Rel(dest, destPath)
+	if err != nil || !staysWithin(dest, rel) {
+		return fmt.Errorf("Archive entry escapes target directory: '%s'", name)
+	}
+	return nil
+}
+
+func writeArchiveFile(destPath string, src io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(destPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode|0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, src); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func extractZip(dest string, reader *zip.Reader, strip int) error {
+	for _, file := range reader.File {
+		destPath, err := archiveEntryPath(dest, file.Name, strip)
+		if err != nil {
+			return err
+		} else if destPath == "" {
+			continue
+		}
+		if err := checkArchivePath(dest, destPath, file.Name); err != nil {
+			return err
+		}
+		if file.FileInfo().IsDir() {
+			if err := os.MkdirAll(destPath, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if !file.Mode().IsRegular() {
+			return fmt.Errorf("Unsupported zip entry: '%s'", file.Name)
+		}
+		src, err := file.Open()
+		if err != nil {
+			return err
+		}
+		err = writeArchiveFile(destPath, src, file.Mode().Perm())
+		src.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractTar(dest string, reader io.Reader, strip int) error {
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("Cannot read tar archive: %v", err)
+		}
+		destPath, err := archiveEntryPath(dest, header.Name, strip)
+		if err != nil {
+			return err
+		} else if destPath == "" {
+			continue
+		}
+		if err := checkArchivePath(dest, destPath, header.Name); err != nil {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(destPath, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err := writeArchiveFile(destPath, tarReader, os.FileMode(header.Mode).Perm()); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			// links may only point at other files within the archive, even
+			// by way of links extracted before them
+			target := header.Linkname
+			if !filepath.IsAbs(target) {
+				dir, _ := filepath.Rel(dest, filepath.Dir(destPath))
+				target = dir + "/" + filepath.ToSlash(target)
+			} else if rel, err := filepath.Rel(dest, target); err == nil {
+				target = rel
+			}
+			if filepath.IsAbs(target) || !staysWithin(dest, target) {
+				return fmt.Errorf("Archive link escapes target directory: '%s'", header.Name)
+			}
+			if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+				return err
+			}
+			if err := os.Symlink(header.Linkname, destPath); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			linkPath, err := archiveEntryPath(dest, header.Linkname, strip)
+			if err != nil {
+				return err
+			} else if linkPath == "" {
+				return fmt.Errorf("Archive link target was stripped: '%s'", header.Name)
+			}
+			if err := checkArchivePath(dest, linkPath, header.Linkname); err != nil {
+				return err
+			}
+			if err := os.Link(linkPath, destPath); err != nil {
+				return err
+			}
+		case tar.TypeXGlobalHeader:
+			// pax metadata; nothing to extract
+		default:
+			return fmt.Errorf("Unsupported tar entry: '%s'", header.Name)
+		}
+	}
+}
+
+// Extracts the archive in 'filename' into 'dest', dropping 'strip' leading
+// path components from each entry.  The format is detected from the content
+// of the file rather than its name.
+func ExtractArchive(dest, filename string, strip int) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	header := make([]byte, 512)
+	count, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return fmt.Errorf("Cannot read archive: %v", err)
+	}
+	if _, err := file.Seek(0, 0); err != nil {
+		return err
+	}
+
+	switch ArchiveFormat(header[:count]) {
+	case FormatZip:
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		reader, err := zip.NewReader(file, info.Size())
+		if err != nil {
+			return fmt.Errorf("Cannot read zip archive: %v", err)
+		}
+		return extractZip(dest, reader, strip)
+	case FormatTar:
+		return extractTar(dest, file, strip)
+	case FormatGzip:
+		reader, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("Cannot read gzip archive: %v", err)
+		}
+		defer reader.Close()
+		return extractTar(dest, reader, strip)
+	case FormatBzip2:
+		return extractTar(dest, bzip2.NewReader(file), strip)
+	case FormatXz:
+		// no xz support in the standard library; stream through the xz tool
+		cmd := exec.Command("xz", "--decompress", "--stdout")
+		cmd.Stdin = file
+		reader, err := cmd.StdoutPipe()
+		if err != nil {
+			return err
+		}
+		if err := cmd.Start(); err != nil {
+			return fmt.Errorf("Cannot run xz: %v", err)
+		}
+		if err := extractTar(dest, reader, strip); err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			return err
+		}
+		return cmd.Wait()
+	}
+	return fmt.Errorf("Unrecognized archive format: '%s'", filename)
+}
@@ -0,0 +1,253 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// test archive entry; a trailing '/' marks a directory
+type testArchiveEntry struct {
+	Name    string
+	Content string
+}
+
+var testArchiveEntries = []testArchiveEntry{
+	{"foo-1.0/", ""},
+	{"foo-1.0/README", "readme"},
+	{"foo-1.0/bar/", ""},
+	{"foo-1.0/bar/bar.go", "package bar"},
+}
+
+func buildTestTar(entries []testArchiveEntry) []byte {
+	buffer := &bytes.Buffer{}
+	writer := tar.NewWriter(buffer)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.Name, Mode: 0644, Size: int64(len(entry.Content))}
+		if entry.Name[len(entry.Name)-1] == '/' {
+			header.Typeflag = tar.TypeDir
+			header.Mode = 0755
+		} else {
+			header.Typeflag = tar.TypeReg
+		}
+		writer.WriteHeader(header)
+		writer.Write([]byte(entry.Content))
+	}
+	writer.Close()
+	return buffer.Bytes()
+}
+
+func buildTestZip(entries []testArchiveEntry) []byte {
+	buffer := &bytes.Buffer{}
+	writer := zip.NewWriter(buffer)
+	for _, entry := range entries {
+		file, _ := writer.Create(entry.Name)
+		file.Write([]byte(entry.Content))
+	}
+	writer.Close()
+	return buffer.Bytes()
+}
+
+func gzipBytes(data []byte) []byte {
+	buffer := &bytes.Buffer{}
+	writer := gzip.NewWriter(buffer)
+	writer.Write(data)
+	writer.Close()
+	return buffer.Bytes()
+}
+
+// compresses data through a command line tool like 'bzip2' or 'xz'
+func pipeBytes(data []byte, tool string) ([]byte, error) {
+	cmd := exec.Command(tool, "--compress", "--stdout")
+	cmd.Stdin = bytes.NewReader(data)
+	return cmd.Output()
+}
+
+func extractTestArchive(t *testing.T, data []byte, strip int) (string, error) {
+	file, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.Remove(file.Name())
+	file.Write(data)
+	file.Close()
+
+	dest, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return dest, ExtractArchive(dest, file.Name(), strip)
+}
+
+func TestExtractArchive(t *testing.T) {
+	entries := testArchiveEntries
+	tarData := buildTestTar(entries)
+	archives := map[string][]byte{
+		"tar":    tarData,
+		"tar.gz": gzipBytes(tarData),
+		"zip":    buildTestZip(entries),
+	}
+	for _, tool := range []string{"bzip2", "xz"} {
+		if data, err := pipeBytes(tarData, tool); err == nil {
+			archives["tar."+tool] = data
+		} else {
+			t.Logf("Skipping tar.%s: %v", tool, err)
+		}
+	}
+
+	for format, data := range archives {
+		for strip, readme := range map[int]string{
+			0: "foo-1.0/README",
+			1: "README",
+		} {
+			dest, err := extractTestArchive(t, data, strip)
+			defer os.RemoveAll(dest)
+			if err != nil {
+				t.Errorf("Error extracting %s archive: %v", format, err)
+				continue
+			}
+			if content, err := ioutil.ReadFile(filepath.Join(dest, readme)); err != nil {
+				t.Errorf("Missing %s from %s archive with strip %d", readme, format, strip)
+			} else if string(content) != "readme" {
+				t.Errorf("Bad content for %s from %s archive: '%s'", readme, format, content)
+			}
+		}
+	}
+
+	// unrecognized content
+	dest, err := extractTestArchive(t, []byte("<html>Not Found</html>"), 0)
+	defer os.RemoveAll(dest)
+	if err == nil {
+		t.Errorf("Non-archive extracted okay")
+	}
+}
+
+func TestExtractArchiveRejectsEscapes(t *testing.T) {
+	for _, name := range []string{
+		"../evil.txt",
+		"foo/../../evil.txt",
+		"/tmp/evil.txt",
+	} {
+		entries := []testArchiveEntry{{name, "evil"}}
+		for format, data := range map[string][]byte{
+			"tar": buildTestTar(entries),
+			"zip": buildTestZip(entries),
+		} {
+			dest, err := extractTestArchive(t, data, 0)
+			defer os.RemoveAll(dest)
+			if err == nil {
+				t.Errorf("%s entry '%v' extracted okay", format, name)
+			}
+		}
+	}
+
+	// symlinks may not point outside of the target
+	buffer := &bytes.Buffer{}
+	writer := tar.NewWriter(buffer)
+	writer.WriteHeader(&tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "../../etc"})
+	writer.Close()
+	dest, err := extractTestArchive(t, buffer.Bytes(), 0)
+	defer os.RemoveAll(dest)
+	if err == nil {
+		t.Errorf("Escaping symlink extracted okay")
+	}
+
+	// nor may they escape by way of other links
+	for _, chain := range [][]tar.Header{
+		{
+			{Name: "y", Typeflag: tar.TypeSymlink, Linkname: "."},
+			{Name: "x", Typeflag: tar.TypeSymlink, Linkname: "y/.."},
+			{Name: "x/escaped.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 4},
+		},
+		{
+			{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "b/.."},
+			{Name: "b", Typeflag: tar.TypeSymlink, Linkname: ".."},
+			{Name: "a/escaped.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 4},
+		},
+	} {
+		parent, err := ioutil.TempDir("", "")
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		defer os.RemoveAll(parent)
+		dest := filepath.Join(parent, "dest")
+		os.Mkdir(dest, 0755)
+
+		buffer := &bytes.Buffer{}
+		writer := tar.NewWriter(buffer)
+		for _, header := range chain {
+			header := header
+			writer.WriteHeader(&header)
+			if header.Typeflag == tar.TypeReg {
+				writer.Write([]byte("evil"))
+			}
+		}
+		writer.Close()
+		archive := filepath.Join(parent, "chain.tar")
+		ioutil.WriteFile(archive, buffer.Bytes(), 0644)
+
+		if err := ExtractArchive(dest, archive, 0); err == nil {
+			t.Errorf("Chained symlinks %v extracted okay", chain[1].Name)
+		}
+		for _, name := range []string{"escaped.txt", "../escaped.txt"} {
+			if Exists(filepath.Join(dest, name)) {
+				t.Errorf("Chained symlinks %v wrote %v", chain[1].Name, name)
+			}
+		}
+	}
+
+	// links that stay inside are fine
+	buffer = &bytes.Buffer{}
+	writer = tar.NewWriter(buffer)
+	writer.WriteHeader(&tar.Header{Name: "foo/", Typeflag: tar.TypeDir, Mode: 0755})
+	writer.WriteHeader(&tar.Header{Name: "foo/link", Typeflag: tar.TypeSymlink, Linkname: "../bar"})
+	writer.WriteHeader(&tar.Header{Name: "bar", Typeflag: tar.TypeReg, Mode: 0644})
+	writer.Close()
+	dest, err = extractTestArchive(t, buffer.Bytes(), 0)
+	defer os.RemoveAll(dest)
+	if err != nil {
+		t.Errorf("Error extracting symlink: %v", err)
+	}
+}
+
+func TestTrimArchiveExt(t *testing.T) {
+	for name, expected := range map[string]string{
+		"foo-1.2.3.tar.gz":  "foo-1.2.3",
+		"foo-1.2.3.tar.bz2": "foo-1.2.3",
+		"foo-1.2.3.tgz":     "foo-1.2.3",
+		"foo-1.2.3.zip":     "foo-1.2.3",
+		"foo-1.2.3":         "foo-1.2.3",
+	} {
+		if result := TrimArchiveExt(name); result != expected {
+			t.Errorf("'%v' trimmed to '%v', expected '%v'", name, result, expected)
+		}
+	}
+}
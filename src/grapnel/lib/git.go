@@ -29,7 +29,11 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
+	"time"
 )
 
 var GitRewriteRules = RewriteRuleArray{
@@ -77,21 +81,361 @@ var GitRewriteRules = RewriteRuleArray{
 	}),
 }
 
-type GitSCM struct{}
+type GitSCM struct {
+	Keyring     string      // GnuPG home directory for signature checks; the default is the user's
+	Credentials Credentials // per-host ssh and HTTP credentials
+	Cache       *Cache      // mirrors kept between runs; optional
+}
 
+// Removes git metadata from the tree, including the '.git' files that
+// checked-out submodules use to point back at their repositories.
 func stripGitRepo(baseDir string) {
-	os.RemoveAll(path.Join(baseDir, ".git"))
+	filepath.Walk(baseDir, func(name string, info os.FileInfo, err error) error {
+		if err != nil || info.Name() != ".git" {
+			return nil
+		}
+		os.RemoveAll(name)
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
+
+// Resolves a submodule url from .gitmodules against 'baseUrl', the real url
+// of the repository that lists it.  Urls that aren't relative are kept.
+func resolveSubmoduleUrl(baseUrl, subUrl string) string {
+	if !strings.HasPrefix(subUrl, "./") && !strings.HasPrefix(subUrl, "../") {
+		return subUrl
+	}
+	baseUrl = strings.TrimSuffix(baseUrl, "/")
+	for {
+		if strings.HasPrefix(subUrl, "./") {
+			subUrl = subUrl[2:]
+		} else if strings.HasPrefix(subUrl, "../") {
+			subUrl = subUrl[3:]
+			if idx := strings.LastIndex(baseUrl, "/"); idx >= 0 {
+				baseUrl = baseUrl[:idx]
+			}
+		} else {
+			return baseUrl + "/" + subUrl
+		}
+	}
+}
+
+// Checks out the submodules of the repository that 'cmd' runs in, which is
+// at 'repoPath' in the library, and their own submodules with 'recursive'.
+// With a cache, each submodule is fetched through a mirror of its url, so
+// that it can be checked out again offline.  'urls' holds the real url of
+// each repository by path, as relative submodule urls resolve against it.
+func (self *GitSCM) checkoutGitSubmodules(cmd *RunContext, repoPath string, urls map[string]string, recursive bool) error {
+	if self.Cache == nil {
+		args := []string{"submodule", "update", "--init"}
+		if recursive {
+			args = append(args, "--recursive")
+		}
+		if err := cmd.Run("git", args...); err != nil {
+			return fmt.Errorf("Failed to update submodules: %s", strings.TrimSpace(cmd.CombinedOutput))
+		}
+		return nil
+	}
+
+	// the url and path of each submodule, by name
+	subUrls := map[string]string{}
+	subPaths := map[string]string{}
+	for _, item := range []struct {
+		Key    string
+		Values map[string]string
+	}{
+		{"url", subUrls},
+		{"path", subPaths},
+	} {
+		if err := cmd.Run("git", "config", "--file", ".gitmodules", "--get-regexp",
+			`^submodule\..*\.`+item.Key+`$`); err != nil {
+			return nil // no submodules
+		}
+		for _, line := range strings.Split(cmd.CombinedOutput, "\n") {
+			if fields := strings.Fields(line); len(fields) == 2 {
+				name := strings.TrimSuffix(strings.TrimPrefix(fields[0], "submodule."), "."+item.Key)
+				item.Values[name] = fields[1]
+			}
+		}
+	}
+	names := []string{}
+	for name := range subUrls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// point each submodule at its mirror
+	for _, name := range names {
+		subUrl := resolveSubmoduleUrl(urls[repoPath], subUrls[name])
+		urls[path.Join(repoPath, subPaths[name])] = subUrl
+		remote := subUrl
+		if mirror, err := self.Cache.UpdateGitMirror(cmd, subUrl); err != nil && self.Cache.Offline {
+			return err
+		} else if err != nil {
+			log.Warn("Fetching submodule '%s' without the cache: %v", subUrl, err)
+		} else {
+			remote = "file://" + mirror
+		}
+		if err := cmd.Run("git", "config", "submodule."+name+".url", remote); err != nil {
+			return fmt.Errorf("Cannot configure submodule: '%s'", name)
+		}
+	}
+
+	// submodules from the local filesystem are refused by default
+	if err := cmd.Run("git", "-c", "protocol.file.allow=always", "submodule", "update"); err != nil {
+		return fmt.Errorf("Failed to update submodules: %s", strings.TrimSpace(cmd.CombinedOutput))
+	}
+	if recursive {
+		for _, name := range names {
+			subCmd := NewRunContext(path.Join(cmd.WorkingDirectory, subPaths[name]))
+			subCmd.Env = cmd.Env
+			if err := self.checkoutGitSubmodules(subCmd, path.Join(repoPath, subPaths[name]), urls, true); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Checks out the submodules of the repository in 'lib.TempDir', fetched
+// from 'repoUrl', moves each one to its pinned commit, and records the
+// commit of every submodule.
+func (self *GitSCM) updateGitSubmodules(cmd *RunContext, repoUrl string, lib *Library) error {
+	recursive := lib.Submodules == SubmodulesRecursive
+	statusArgs := []string{"submodule", "status"}
+	if recursive {
+		statusArgs = append(statusArgs, "--recursive")
+	}
+	urls := map[string]string{"": repoUrl}
+	if err := self.checkoutGitSubmodules(cmd, "", urls, recursive); err != nil {
+		return err
+	}
+
+	// pin in path order, so that parents move before their own submodules
+	paths := []string{}
+	for subPath := range lib.SubmoduleCommits {
+		paths = append(paths, subPath)
+	}
+	sort.Strings(paths)
+	for _, subPath := range paths {
+		commit := lib.SubmoduleCommits[subPath]
+		subCmd := NewRunContext(path.Join(lib.TempDir, subPath))
+		subCmd.Env = cmd.Env
+		if err := subCmd.Run("git", "checkout", "--quiet", commit); err != nil {
+			if err := subCmd.Run("git", "fetch", "origin", commit); err != nil {
+				return fmt.Errorf("Cannot fetch commit '%s' for submodule: '%s'", commit, subPath)
+			}
+			if err := subCmd.Run("git", "checkout", "--quiet", commit); err != nil {
+				return fmt.Errorf("Failed to checkout commit '%s' for submodule: '%s'", commit, subPath)
+			}
+		}
+		if recursive {
+			if err := self.checkoutGitSubmodules(subCmd, subPath, urls, true); err != nil {
+				return fmt.Errorf("Failed to update submodules of '%s': %v", subPath, err)
+			}
+		}
+	}
+
+	// record what ended up checked out; lines look like '+<commit> <path> (<describe>)'
+	if err := cmd.Run("git", statusArgs...); err != nil {
+		return fmt.Errorf("Failed to acquire submodule status")
+	}
+	lib.SubmoduleCommits = map[string]string{}
+	for _, line := range strings.Split(cmd.CombinedOutput, "\n") {
+		fields := strings.Fields(strings.TrimLeft(line, " +-U"))
+		if len(fields) >= 2 {
+			lib.SubmoduleCommits[fields[1]] = fields[0]
+		}
+	}
+	return nil
+}
+
+// Returns true if 'branch' is a glob, or a regex wrapped in slashes, rather
+// than the name of a single branch.
+func isBranchPattern(branch string) bool {
+	return strings.ContainsAny(branch, "*?[") ||
+		(len(branch) > 1 && strings.HasPrefix(branch, "/") && strings.HasSuffix(branch, "/"))
+}
+
+func compileBranchPattern(pattern string) (*regexp.Regexp, error) {
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		return regexp.Compile(pattern[1 : len(pattern)-1])
+	}
+	// translate the glob; '*' and '?' never match across a '/'
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.Replace(expr, `\*`, `[^/]*`, -1)
+	expr = strings.Replace(expr, `\?`, `[^/]`, -1)
+	expr = strings.Replace(expr, `\[`, `[`, -1)
+	expr = strings.Replace(expr, `\]`, `]`, -1)
+	return regexp.Compile("^" + expr + "$")
+}
+
+// Picks the branch on the remote that matches 'pattern' and has the highest
+// version number in its name.  If no matching branch carries a version, the
+// branch with the most recent commit is used instead.
+func resolveBranchPattern(cmd *RunContext, repoUrl, pattern string) (string, error) {
+	matcher, err := compileBranchPattern(pattern)
+	if err != nil {
+		return "", fmt.Errorf("Bad branch pattern '%s': %v", pattern, err)
+	}
+	if err := cmd.Run("git", "ls-remote", "--heads", repoUrl); err != nil {
+		return "", fmt.Errorf("Failed to list branches for: '%s'", repoUrl)
+	}
+
+	branches := []string{}
+	var bestBranch string
+	var bestVersion *Version
+	for _, line := range strings.Split(cmd.CombinedOutput, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || !strings.HasPrefix(fields[1], "refs/heads/") {
+			continue
+		}
+		name := strings.TrimPrefix(fields[1], "refs/heads/")
+		if !matcher.MatchString(name) {
+			continue
+		}
+		branches = append(branches, name)
+		if ver, err := ParseVersion(name); err == nil {
+			if bestVersion == nil || ver.Compare(bestVersion) > 0 {
+				bestBranch = name
+				bestVersion = ver
+			}
+		}
+	}
+	if len(branches) == 0 {
+		return "", fmt.Errorf("No branches match pattern: '%s'", pattern)
+	}
+	if bestVersion != nil {
+		return bestBranch, nil
+	}
+
+	// fetch the tip of each branch into a scratch repo to compare commit dates
+	probeRoot, err := ioutil.TempDir("", "")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(probeRoot)
+	probe := NewRunContext(probeRoot)
+	probe.Env = cmd.Env
+	args := []string{"fetch", "--depth=1", repoUrl}
+	for _, name := range branches {
+		args = append(args, "refs/heads/"+name+":refs/heads/"+name)
+	}
+	if err := probe.Run("git", "init", "--bare"); err != nil {
+		return "", err
+	}
+	if err := probe.Run("git", args...); err != nil {
+		return "", fmt.Errorf("Failed to fetch branches for: '%s'", repoUrl)
+	}
+	if err := probe.Run("git", "for-each-ref", "--sort=-committerdate", "--count=1",
+		"--format=%(refname:short)", "refs/heads"); err != nil {
+		return "", fmt.Errorf("Failed to acquire ref list for depenency")
+	}
+	return strings.TrimSpace(probe.CombinedOutput), nil
+}
+
+// Lists the tags on the remote that carry a version, and picks one according
+// to the dependency's version spec and strategy.
+func selectGitTag(cmd *RunContext, repoUrl string, dep *Dependency) (string, *Version, error) {
+	if err := cmd.Run("git", "ls-remote", "--tags", repoUrl); err != nil {
+		return "", nil, fmt.Errorf("Failed to list tags for: '%s'", repoUrl)
+	}
+	tags := []string{}
+	for _, line := range strings.Split(cmd.CombinedOutput, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || !strings.HasPrefix(fields[1], "refs/tags/") ||
+			strings.HasSuffix(fields[1], "^{}") {
+			continue // skip peeled entries for annotated tags
+		}
+		tags = append(tags, strings.TrimPrefix(fields[1], "refs/tags/"))
+	}
+	return SelectTag(dep.VersionSpec, dep.Strategy, tags)
+}
+
+// Checks out 'rev', a tag or commit hash, fetching as little history as
+// possible.  Servers that refuse to hand out a single commit by hash get a
+// full fetch of 'branch' instead.
+func fetchGitRevision(cmd *RunContext, repoUrl, branch, rev string) error {
+	if err := cmd.Run("git", "fetch", "--depth=1", repoUrl, rev); err == nil {
+		return cmd.Run("git", "checkout", "--quiet", "FETCH_HEAD")
+	}
+	log.Debug("Shallow fetch of '%s' failed; fetching branch '%s'", rev, branch)
+	if err := cmd.Run("git", "fetch", "--tags", repoUrl, "refs/heads/"+branch); err != nil {
+		return err
+	}
+	return cmd.Run("git", "checkout", "--quiet", rev)
+}
+
+// Reports whether gpg status output has a good signature with full or
+// ultimate trust.
+func hasTrustedSignature(status string) bool {
+	good, trusted := false, false
+	for _, line := range strings.Split(status, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "[GNUPG:]" {
+			continue
+		}
+		switch fields[1] {
+		case "GOODSIG":
+			good = true
+		case "TRUST_FULLY", "TRUST_ULTIMATE":
+			trusted = true
+		}
+	}
+	return good && trusted
+}
+
+// Checks the signature on the checked-out tag or commit, and fails if it is
+// missing, bad, or made by a key that isn't fully trusted in the keyring.  Tag
+// objects are fetched from 'source'.
+func (self *GitSCM) verifySignature(cmd *RunContext, source string, lib *Library) error {
+	var args []string
+	switch lib.Verify {
+	case VerifySignedTag:
+		// make sure the tag object itself is present, and not just its commit
+		refspec := "refs/tags/" + lib.Tag + ":refs/tags/" + lib.Tag
+		if err := cmd.Run("git", "fetch", "--depth=1", source, refspec); err != nil {
+			return fmt.Errorf("Cannot verify the signature of '%s', as it is not a tag", lib.Tag)
+		}
+		args = []string{"verify-tag", "--raw", lib.Tag}
+	case VerifySignedCommit:
+		args = []string{"verify-commit", "--raw", "HEAD"}
+	default:
+		return nil
+	}
+	verifyCmd := NewRunContext(cmd.WorkingDirectory)
+	verifyCmd.Env = cmd.Env
+	if self.Keyring != "" {
+		verifyCmd.Env = append(append([]string{}, cmd.Env...), "GNUPGHOME="+self.Keyring)
+	}
+	if err := verifyCmd.Run("git", args...); err != nil {
+		return fmt.Errorf("Failed to verify signature on '%s' for dependency '%s': %s",
+			lib.Tag, lib.Import, strings.TrimSpace(verifyCmd.CombinedOutput))
+	}
+	// git accepts any key in the keyring, so check its trust level as well
+	if !hasTrustedSignature(verifyCmd.CombinedOutput) {
+		return fmt.Errorf("Signature on '%s' for dependency '%s' is not from a fully trusted key",
+			lib.Tag, lib.Import)
+	}
+	log.Info("Verified signature on '%s' for: '%s'", lib.Tag, lib.Import)
+	return nil
 }
 
 func (self *GitSCM) Resolve(dep *Dependency) (*Library, error) {
 	lib := NewLibrary(dep)
 
-	// fix the tag, and default branch
+	// fix the default branch
 	if lib.Branch == "" {
 		lib.Branch = "master"
 	}
-	if lib.Tag == "" {
-		lib.Tag = "HEAD"
+	if lib.Tag != "" && !lib.Before.IsZero() {
+		return nil, fmt.Errorf("Cannot specify both 'tag' and 'before' for dependency: '%s'", lib.Import)
+	}
+	if !lib.VersionSpec.IsUnversioned() && !lib.Before.IsZero() {
+		return nil, fmt.Errorf("Cannot specify both 'version' and 'before' for dependency: '%s'", lib.Import)
 	}
 
 	log.Info("Fetching Git Dependency: '%s'", lib.Import)
@@ -103,15 +447,52 @@ func (self *GitSCM) Resolve(dep *Dependency) (*Library, error) {
 	}
 	lib.TempDir = tempRoot
 	cmd := NewRunContext(tempRoot)
+	if err := cmd.Run("git", "init", "--quiet"); err != nil {
+		return nil, fmt.Errorf("Cannot create repository for dependency: '%s'", lib.Import)
+	}
+
+	// resolve any branch pattern against the remote, and confirm the branch;
+	// offline, misses are only recorded once every candidate url has failed
+	offline := self.Cache != nil && self.Cache.Offline
+	mirrored := false
+	probe := func(repoUrl string) error {
+		cmd.Env = nil
+		if cred := self.Credentials.Find(repoUrl); cred != nil {
+			env, err := cred.GitEnv()
+			if err != nil {
+				return err
+			}
+			cmd.Env = env
+		}
+		// offline, the cached mirror stands in for the remote
+		remote := repoUrl
+		if offline {
+			mirror := self.Cache.GitMirrorPath(repoUrl)
+			if !Exists(mirror) {
+				return fmt.Errorf("No cached mirror of '%s'", repoUrl)
+			}
+			mirrored = true
+			remote = "file://" + mirror
+		}
+		if isBranchPattern(lib.Branch) {
+			branch, err := resolveBranchPattern(cmd, remote, lib.Branch)
+			if err != nil {
+				return err
+			}
+			log.Info("Branch pattern '%s' resolved to: '%s'", lib.Branch, branch)
+			lib.Branch = branch
+		}
+		return cmd.Run("git", "ls-remote", "--exit-code", "--heads", remote, "refs/heads/"+lib.Branch)
+	}
 
-	// use the configured url and acquire the depified branch
+	// use the configured url and find the specified branch
 	log.Info("Fetching remote data for %s", lib.Import)
 	if lib.Url == nil {
 		// try all supported protocols against a URL composed from the import
 		for _, protocol := range []string{"http", "https", "git", "ssh"} {
 			packageUrl := protocol + "://" + lib.Import
 			log.Warn("Synthesizing url from import: '%s'", packageUrl)
-			if err := cmd.Run("git", "clone", packageUrl, "-b", lib.Branch, tempRoot); err != nil {
+			if err = probe(packageUrl); err != nil {
 				log.Warn("Failed to fetch: '%s'", packageUrl)
 				continue
 			}
@@ -119,24 +500,99 @@ func (self *GitSCM) Resolve(dep *Dependency) (*Library, error) {
 			break
 		}
 		if err != nil {
+			if offline && !mirrored {
+				return nil, self.Cache.Missing("git mirror for import", lib.Import)
+			}
 			return nil, fmt.Errorf("Cannot download dependency: '%s'", lib.Import)
 		}
-	} else if err := cmd.Run("git", "clone", lib.Url.String(), "-b", lib.Branch, tempRoot); err != nil {
+	} else if err := probe(lib.Url.String()); err != nil {
+		if offline && !mirrored {
+			return nil, self.Cache.Missing("git mirror", lib.Url.String())
+		}
 		return nil, fmt.Errorf("Cannot download dependency: '%s'", lib.Url.String())
 	}
+	repoUrl := lib.Url.String()
 
-	// move to the specified commit/tag/hash
-	// check out a depific commit - may be a tag, commit hash or HEAD
-	if err := cmd.Run("git", "checkout", lib.Tag); err != nil {
-		return nil, fmt.Errorf("Failed to checkout tag: '%s'", lib.Tag)
+	// relative submodule urls are resolved against 'origin'
+	if err := cmd.Run("git", "remote", "add", "origin", repoUrl); err != nil {
+		return nil, fmt.Errorf("Cannot configure remote for dependency: '%s'", repoUrl)
 	}
 
-	// Pin the Tag to a commit hash if we just have "HEAD" as the 'Tag'
-	if lib.Tag == "HEAD" {
-		if err := cmd.Run("git", "rev-list", "--all", "--max-count=1"); err != nil {
-			return nil, fmt.Errorf("Failed to checkout tag: '%s'", lib.Tag)
+	// fetch through a mirror in the cache when there is one; the mirror only
+	// needs what is new since the last run
+	source := repoUrl
+	if self.Cache != nil {
+		if mirror, err := self.Cache.UpdateGitMirror(cmd, repoUrl); err != nil && self.Cache.Offline {
+			return nil, err
+		} else if err != nil {
+			log.Warn("Fetching '%s' without the cache: %v", repoUrl, err)
 		} else {
-			lib.Tag = strings.TrimSpace(cmd.CombinedOutput)
+			source = "file://" + mirror
+		}
+	}
+
+	// only check out the subdir, if this version of git can do that
+	if lib.Subdir != "" {
+		if err := cmd.Run("git", "sparse-checkout", "set", lib.Subdir); err != nil {
+			log.Warn("Sparse checkout is unavailable; checking out all of: '%s'", lib.Import)
+		}
+	}
+
+	// pick a tag by version, unless the dependency names one
+	if lib.Tag == "" && !lib.VersionSpec.IsUnversioned() {
+		if lib.Tag, lib.Version, err = selectGitTag(cmd, source, dep); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case !lib.Before.IsZero():
+		// pin the tag to the last commit on the branch at or before the cutoff
+		if err := cmd.Run("git", "fetch", source, "refs/heads/"+lib.Branch); err != nil {
+			return nil, fmt.Errorf("Cannot download dependency: '%s'", repoUrl)
+		}
+		cutoff := lib.Before.Format(time.RFC3339)
+		if err := cmd.Run("git", "rev-list", "--first-parent", "--max-count=1",
+			"--before="+cutoff, "FETCH_HEAD"); err != nil {
+			return nil, fmt.Errorf("Failed to acquire commit list for dependency")
+		}
+		lib.Tag = strings.TrimSpace(cmd.CombinedOutput)
+		if lib.Tag == "" {
+			return nil, fmt.Errorf("No commit on branch '%s' at or before %s", lib.Branch, cutoff)
+		}
+		log.Info("Pinned %s to %s as of %s", lib.Import, lib.Tag, cutoff)
+		if err := cmd.Run("git", "checkout", "--quiet", lib.Tag); err != nil {
+			return nil, fmt.Errorf("Failed to checkout tag: '%s'", lib.Tag)
+		}
+	case lib.Tag == "":
+		// pin the tag to the tip of the branch
+		if err := fetchGitRevision(cmd, source, lib.Branch, "refs/heads/"+lib.Branch); err != nil {
+			return nil, fmt.Errorf("Cannot download dependency: '%s'", repoUrl)
+		}
+		if err := cmd.Run("git", "rev-parse", "HEAD"); err != nil {
+			return nil, fmt.Errorf("Failed to checkout branch: '%s'", lib.Branch)
+		}
+		lib.Tag = strings.TrimSpace(cmd.CombinedOutput)
+	default:
+		// check out a specific commit - may be a tag or commit hash
+		if err := fetchGitRevision(cmd, source, lib.Branch, lib.Tag); err != nil {
+			return nil, fmt.Errorf("Failed to checkout tag: '%s'", lib.Tag)
+		}
+	}
+
+	if err := cmd.Run("git", "rev-parse", "HEAD"); err != nil {
+		return nil, fmt.Errorf("Failed to identify commit for: '%s'", lib.Tag)
+	}
+	lib.Commit = strings.TrimSpace(cmd.CombinedOutput)
+
+	if err := self.verifySignature(cmd, source, lib); err != nil {
+		return nil, err
+	}
+
+	// submodules need the repository metadata, so this happens before stripping it
+	if lib.Submodules != SubmodulesNone {
+		if err := self.updateGitSubmodules(cmd, repoUrl, lib); err != nil {
+			return nil, err
 		}
 	}
 
@@ -148,33 +604,13 @@ func (self *GitSCM) Resolve(dep *Dependency) (*Library, error) {
 		return lib, nil
 	}
 
-	// find latest version match
-	if err := cmd.Run("git", "for-each-ref", "refs/tags", "--sort=taggerdate",
-		"--format=%(refname:short)"); err != nil {
-		return nil, fmt.Errorf("Failed to acquire ref list for depenency")
-	} else {
-		for _, line := range strings.Split(cmd.CombinedOutput, "\n") {
-			log.Debug("%v", line)
-			if ver, err := ParseVersion(line); err == nil {
-				log.Debug("ver: %v", ver)
-				if dep.VersionSpec.IsSatisfiedBy(ver) {
-					lib.Tag = line
-					lib.Version = ver
-					// move to this tag in the history
-					if err := cmd.Run("git", "checkout", lib.Tag); err != nil {
-						return nil, fmt.Errorf("Failed to checkout tag: '%s'", lib.Tag)
-					}
-					break
-				}
-			} else {
-				log.Debug("Parse git tag err: %v", err)
-			}
-		}
-	}
-
-	// fail if the tag cannot be determined.
+	// a tag given up front still has to satisfy the version spec
 	if lib.Version == nil {
-		return nil, fmt.Errorf("Cannot find a tag for dependency version specification: %v.", lib.VersionSpec)
+		if ver, err := ParseVersion(lib.Tag); err == nil && lib.VersionSpec.IsSatisfiedBy(ver) {
+			lib.Version = ver
+		} else {
+			return nil, fmt.Errorf("Tag '%s' does not satisfy version specification: %v.", lib.Tag, lib.VersionSpec)
+		}
 	}
 
 	log.Info("Resolved: %s %v", lib.Import, lib.Version)
@@ -182,6 +618,44 @@ func (self *GitSCM) Resolve(dep *Dependency) (*Library, error) {
 	return lib, nil
 }
 
-func (self *GitSCM) ToDSD(*Library) string {
-	return ""
+func (self *GitSCM) ToDSD(lib *Library) string {
+	lines := []string{
+		dsdCommand("git", "init", "-q", "$dir"),
+		dsdCommand("git", "-C", "$dir", "remote", "add", "origin", lib.Url.String()),
+		"if " + dsdCommand("git", "-C", "$dir", "fetch", "-q", "--depth=1", "origin", lib.Tag) + "; then",
+		"\t" + dsdCommand("git", "-C", "$dir", "checkout", "-q", "FETCH_HEAD"),
+		"else",
+		"\t" + dsdCommand("git", "-C", "$dir", "fetch", "-q", "--tags", "origin", "refs/heads/"+lib.Branch),
+		"\t" + dsdCommand("git", "-C", "$dir", "checkout", "-q", lib.Tag),
+		"fi",
+		dsdCommand("check_equal", "commit", lib.Commit, "$(git -C \"$dir\" rev-parse HEAD)"),
+	}
+	if lib.Submodules != SubmodulesNone {
+		args := []string{"git", "-C", "$dir", "submodule", "update", "-q", "--init"}
+		if lib.Submodules == SubmodulesRecursive {
+			args = append(args, "--recursive")
+		}
+		lines = append(lines, dsdCommand(args...))
+
+		// pin in path order, as Resolve does
+		paths := []string{}
+		for subPath := range lib.SubmoduleCommits {
+			paths = append(paths, subPath)
+		}
+		sort.Strings(paths)
+		for _, subPath := range paths {
+			subDir := "$dir/" + subPath
+			commit := lib.SubmoduleCommits[subPath]
+			lines = append(lines,
+				dsdCommand("git", "-C", subDir, "checkout", "-q", commit)+" || {",
+				"\t"+dsdCommand("git", "-C", subDir, "fetch", "-q", "origin", commit),
+				"\t"+dsdCommand("git", "-C", subDir, "checkout", "-q", commit),
+				"}")
+			if lib.Submodules == SubmodulesRecursive {
+				lines = append(lines, dsdCommand("git", "-C", subDir, "submodule", "update", "-q", "--init", "--recursive"))
+			}
+		}
+	}
+	lines = append(lines, dsdCommand("strip_git", "$dir"))
+	return dsdLines(lines...)
 }
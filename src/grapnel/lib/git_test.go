@@ -25,8 +25,13 @@ THE SOFTWARE.
 import (
 	log "grapnel/log"
 	. "grapnel/testing"
+	"io/ioutil"
 	"os"
+	"os/exec"
+	"path"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestGitSource(t *testing.T) {
@@ -58,3 +63,485 @@ func TestGitSource(t *testing.T) {
 		t.Error("%v", err)
 	}
 }
+
+func TestCompileBranchPattern(t *testing.T) {
+	for _, item := range []struct {
+		Pattern string
+		Branch  string
+		Result  bool
+	}{
+		{"release-*", "release-2015.01", true},
+		{"release-*", "release/2015.01", false},
+		{"release-*", "master", false},
+		{"v?", "v1", true},
+		{"v[0-9].x", "v2.x", true},
+		{"v[0-9].x", "vA.x", false},
+		{`/^release-\d+\.\d+$/`, "release-2015.01", true},
+		{`/^release-\d+\.\d+$/`, "release-2015.01-rc", false},
+	} {
+		if !isBranchPattern(item.Pattern) {
+			t.Errorf("'%v' should be treated as a pattern", item.Pattern)
+		}
+		matcher, err := compileBranchPattern(item.Pattern)
+		if err != nil {
+			t.Errorf("Error compiling pattern '%v': %v", item.Pattern, err)
+			continue
+		}
+		if matcher.MatchString(item.Branch) != item.Result {
+			t.Errorf("'%v' matching '%v' == %v, expected %v",
+				item.Pattern, item.Branch, !item.Result, item.Result)
+		}
+	}
+	if isBranchPattern("release-2015.01") {
+		t.Errorf("Plain branch name treated as a pattern")
+	}
+}
+
+func TestResolveBranchPattern(t *testing.T) {
+	InitTestLogging()
+
+	basePath := BuildTestGitRepo("gitrepo")
+	defer os.RemoveAll(basePath)
+	repoPath := path.Join(basePath, "gitrepo")
+
+	cmd := NewRunContext(repoPath)
+	for _, data := range [][]string{
+		{"git", "branch", "release-2015.02"},
+		{"git", "branch", "release-2015.10"},
+		{"git", "branch", "release-2015.09"},
+		{"git", "branch", "feature-a"},
+		{"git", "checkout", "-q", "-b", "feature-b"},
+		{"sh", "-c", "GIT_COMMITTER_DATE=2030-01-01T00:00:00Z git commit -q --allow-empty -m newer"},
+		{"git", "checkout", "-q", "master"},
+	} {
+		cmd.MustRun(data[0], data[1:]...)
+	}
+
+	for pattern, expected := range map[string]string{
+		"release-*": "release-2015.10",
+		"feature-*": "feature-b",
+	} {
+		if branch, err := resolveBranchPattern(cmd, repoPath, pattern); err != nil {
+			t.Errorf("Error resolving '%v': %v", pattern, err)
+		} else if branch != expected {
+			t.Errorf("'%v' resolved to '%v', expected '%v'", pattern, branch, expected)
+		}
+	}
+	if _, err := resolveBranchPattern(cmd, repoPath, "hotfix-*"); err == nil {
+		t.Errorf("Pattern with no matching branches resolved okay")
+	}
+}
+
+func TestGitSelectAndFetch(t *testing.T) {
+	InitTestLogging()
+
+	basePath := BuildTestGitRepo("gitrepo")
+	defer os.RemoveAll(basePath)
+	repoPath := path.Join(basePath, "gitrepo")
+	repoUrl := "file://" + repoPath
+
+	cmd := NewRunContext(repoPath)
+	for _, data := range [][]string{
+		{"touch", "bar.txt"},
+		{"git", "add", "bar.txt"},
+		{"git", "commit", "-q", "-m", "third commit"},
+		{"git", "tag", "-a", "-m", "annotated", "v2.0"},
+		{"git", "commit", "-q", "--allow-empty", "-m", "untagged commit"},
+		{"git", "rev-list", "--max-parents=0", "HEAD"},
+	} {
+		cmd.MustRun(data[0], data[1:]...)
+	}
+	firstCommit := strings.TrimSpace(cmd.CombinedOutput)
+	cmd.MustRun("git", "rev-parse", "HEAD")
+	tipCommit := strings.TrimSpace(cmd.CombinedOutput)
+
+	libsrc := &GitSCM{}
+	for _, item := range []struct {
+		Version  string
+		Tag      string
+		Strategy int
+		Result   string
+		Present  string
+		Absent   string
+	}{
+		{"1", "", StrategyNewest, "v1.1", "foo.txt", "bar.txt"},
+		{">=1", "", StrategyMinimal, "v1.0", "README", "foo.txt"},
+		{"2", "", StrategyNewest, "v2.0", "bar.txt", ""},
+		{"1.1", "v1.1", StrategyNewest, "v1.1", "foo.txt", "bar.txt"},
+		{"", "", StrategyNewest, tipCommit, "bar.txt", ""},
+		{"", firstCommit, StrategyNewest, firstCommit, "README", "foo.txt"},
+	} {
+		dep, err := NewDependency("foo/bar/baz", repoUrl, item.Version)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		dep.Tag = item.Tag
+		dep.Strategy = item.Strategy
+		lib, err := libsrc.Resolve(dep)
+		if err != nil {
+			t.Errorf("Error resolving '%v' (tag '%v'): %v", item.Version, item.Tag, err)
+			continue
+		}
+		defer os.RemoveAll(lib.TempDir)
+		if lib.Tag != item.Result {
+			t.Errorf("'%v' resolved to '%v', expected '%v'", item.Version, lib.Tag, item.Result)
+		}
+		if !Exists(path.Join(lib.TempDir, item.Present)) {
+			t.Errorf("'%v' is missing '%v'", item.Result, item.Present)
+		}
+		if item.Absent != "" && Exists(path.Join(lib.TempDir, item.Absent)) {
+			t.Errorf("'%v' should not contain '%v'", item.Result, item.Absent)
+		}
+		if Exists(path.Join(lib.TempDir, ".git")) {
+			t.Errorf("'%v' still has a .git directory", item.Result)
+		}
+	}
+
+	// a cutoff in the future pins the tip of the branch
+	dep, _ := NewDependency("foo/bar/baz", repoUrl, "")
+	dep.Before = time.Now().Add(time.Hour)
+	if lib, err := libsrc.Resolve(dep); err != nil {
+		t.Errorf("Error resolving by date: %v", err)
+	} else {
+		defer os.RemoveAll(lib.TempDir)
+		if lib.Tag != tipCommit {
+			t.Errorf("Date resolved to '%v', expected '%v'", lib.Tag, tipCommit)
+		}
+	}
+	dep, _ = NewDependency("foo/bar/baz", repoUrl, "1")
+	dep.Before = time.Now()
+	if _, err := libsrc.Resolve(dep); err == nil {
+		t.Errorf("Resolved with both 'version' and 'before' okay")
+	}
+
+	// negative tests
+	for _, item := range []struct {
+		Version string
+		Tag     string
+	}{
+		{"3", ""},
+		{"2", "v1.0"},
+		{"", "v9.9"},
+	} {
+		dep, _ := NewDependency("foo/bar/baz", repoUrl, item.Version)
+		dep.Tag = item.Tag
+		if _, err := libsrc.Resolve(dep); err == nil {
+			t.Errorf("Resolved '%v' (tag '%v') okay", item.Version, item.Tag)
+		}
+	}
+}
+
+func TestGitSubmodules(t *testing.T) {
+	InitTestLogging()
+
+	// submodules served from the local filesystem are refused by default
+	for key, value := range map[string]string{
+		"GIT_CONFIG_COUNT":   "1",
+		"GIT_CONFIG_KEY_0":   "protocol.file.allow",
+		"GIT_CONFIG_VALUE_0": "always",
+	} {
+		os.Setenv(key, value)
+		defer os.Unsetenv(key)
+	}
+
+	// superrepo/vendor/sub -> subrepo, subrepo/nested -> nestedrepo
+	basePaths := []string{}
+	repoPaths := map[string]string{}
+	for _, name := range []string{"nestedrepo", "subrepo", "superrepo"} {
+		basePath := BuildTestGitRepo(name)
+		basePaths = append(basePaths, basePath)
+		repoPaths[name] = path.Join(basePath, name)
+	}
+	defer func() {
+		for _, basePath := range basePaths {
+			os.RemoveAll(basePath)
+		}
+	}()
+	subCmd := NewRunContext(repoPaths["subrepo"])
+	subCmd.MustRun("git", "rev-list", "--max-parents=0", "HEAD")
+	subFirstCommit := strings.TrimSpace(subCmd.CombinedOutput)
+	subCmd.MustRun("git", "submodule", "add", "file://"+repoPaths["nestedrepo"], "nested")
+	subCmd.MustRun("git", "commit", "-q", "-m", "add nested")
+	subCmd.MustRun("git", "rev-parse", "HEAD")
+	subTipCommit := strings.TrimSpace(subCmd.CombinedOutput)
+	superCmd := NewRunContext(repoPaths["superrepo"])
+	superCmd.MustRun("git", "submodule", "add", "file://"+repoPaths["subrepo"], "vendor/sub")
+	superCmd.MustRun("git", "commit", "-q", "-m", "add sub")
+
+	libsrc := &GitSCM{}
+	for _, item := range []struct {
+		Submodules int
+		Pins       map[string]string
+		Present    []string
+		Absent     []string
+		Commits    []string
+	}{
+		{SubmodulesNone, nil,
+			[]string{"README", "vendor/sub"},
+			[]string{"vendor/sub/README"},
+			[]string{}},
+		{SubmodulesTop, nil,
+			[]string{"vendor/sub/README", "vendor/sub/nested"},
+			[]string{"vendor/sub/nested/README", "vendor/sub/.git"},
+			[]string{"vendor/sub=" + subTipCommit}},
+		{SubmodulesRecursive, nil,
+			[]string{"vendor/sub/README", "vendor/sub/nested/README"},
+			[]string{"vendor/sub/.git", "vendor/sub/nested/.git"},
+			[]string{"vendor/sub=" + subTipCommit, "vendor/sub/nested="}},
+		{SubmodulesTop, map[string]string{"vendor/sub": subFirstCommit},
+			[]string{"vendor/sub/README"},
+			[]string{"vendor/sub/foo.txt", "vendor/sub/nested"},
+			[]string{"vendor/sub=" + subFirstCommit}},
+	} {
+		dep, err := NewDependency("foo/bar/baz", "file://"+repoPaths["superrepo"], "")
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		dep.Submodules = item.Submodules
+		dep.SubmoduleCommits = item.Pins
+		lib, err := libsrc.Resolve(dep)
+		if err != nil {
+			t.Errorf("Error resolving with submodules %v: %v", item.Submodules, err)
+			continue
+		}
+		defer os.RemoveAll(lib.TempDir)
+		for _, name := range item.Present {
+			if !Exists(path.Join(lib.TempDir, name)) {
+				t.Errorf("Submodules %v: missing '%v'", item.Submodules, name)
+			}
+		}
+		for _, name := range item.Absent {
+			if Exists(path.Join(lib.TempDir, name)) {
+				t.Errorf("Submodules %v: should not contain '%v'", item.Submodules, name)
+			}
+		}
+		if len(lib.SubmoduleCommits) != len(item.Commits) {
+			t.Errorf("Submodules %v: pinned %v, expected %v", item.Submodules, lib.SubmoduleCommits, item.Commits)
+		}
+		for _, expected := range item.Commits {
+			parts := strings.SplitN(expected, "=", 2)
+			if commit, ok := lib.SubmoduleCommits[parts[0]]; !ok || !strings.HasPrefix(commit, parts[1]) {
+				t.Errorf("Submodules %v: bad pin for '%v': '%v'", item.Submodules, parts[0], commit)
+			}
+		}
+	}
+}
+
+func TestResolveSubmoduleUrl(t *testing.T) {
+	for _, item := range []struct {
+		Base     string
+		Url      string
+		Expected string
+	}{
+		{"https://example.com/foo/bar", "https://example.com/baz", "https://example.com/baz"},
+		{"https://example.com/foo/bar", "./baz", "https://example.com/foo/bar/baz"},
+		{"https://example.com/foo/bar/", "../baz", "https://example.com/foo/baz"},
+		{"https://example.com/foo/bar", "../../baz/gorf", "https://example.com/baz/gorf"},
+	} {
+		if result := resolveSubmoduleUrl(item.Base, item.Url); result != item.Expected {
+			t.Errorf("Resolved '%v' against '%v' as '%v'. Expected: '%v'", item.Url, item.Base, result, item.Expected)
+		}
+	}
+}
+
+func TestGitSubmodulesOffline(t *testing.T) {
+	InitTestLogging()
+
+	// submodules served from the local filesystem are refused by default
+	for key, value := range map[string]string{
+		"GIT_CONFIG_COUNT":   "1",
+		"GIT_CONFIG_KEY_0":   "protocol.file.allow",
+		"GIT_CONFIG_VALUE_0": "always",
+	} {
+		os.Setenv(key, value)
+		defer os.Unsetenv(key)
+	}
+
+	// superrepo/vendor/sub -> subrepo, subrepo/nested -> nestedrepo
+	basePaths := []string{}
+	repoPaths := map[string]string{}
+	for _, name := range []string{"nestedrepo", "subrepo", "superrepo"} {
+		basePath := BuildTestGitRepo(name)
+		basePaths = append(basePaths, basePath)
+		repoPaths[name] = path.Join(basePath, name)
+	}
+	defer func() {
+		for _, basePath := range basePaths {
+			os.RemoveAll(basePath)
+		}
+	}()
+	subCmd := NewRunContext(repoPaths["subrepo"])
+	subCmd.MustRun("git", "submodule", "add", "file://"+repoPaths["nestedrepo"], "nested")
+	subCmd.MustRun("git", "commit", "-q", "-m", "add nested")
+	superCmd := NewRunContext(repoPaths["superrepo"])
+	superCmd.MustRun("git", "submodule", "add", "file://"+repoPaths["subrepo"], "vendor/sub")
+	superCmd.MustRun("git", "commit", "-q", "-m", "add sub")
+	repoUrl := "file://" + repoPaths["superrepo"]
+
+	cacheRoot, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(cacheRoot)
+	cache := NewCache(cacheRoot)
+	libsrc := &GitSCM{Cache: cache}
+	dep, _ := NewDependency("foo/bar/baz", repoUrl, "")
+	dep.Submodules = SubmodulesRecursive
+
+	// an empty cache misses the submodules as well as the repository
+	cache.Offline = true
+	if _, err := libsrc.Resolve(dep); err == nil {
+		t.Fatalf("Offline resolve succeeded with an empty cache")
+	}
+
+	// fill the cache, then resolve again without the source repositories
+	cache.Offline = false
+	lib, err := libsrc.Resolve(dep)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	os.RemoveAll(lib.TempDir)
+	for _, basePath := range basePaths {
+		os.RemoveAll(basePath)
+	}
+	cache.Offline = true
+	lib, err = libsrc.Resolve(dep)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(lib.TempDir)
+	for _, name := range []string{"README", "vendor/sub/README", "vendor/sub/nested/README"} {
+		if !Exists(path.Join(lib.TempDir, name)) {
+			t.Errorf("Missing '%v' from offline install", name)
+		}
+	}
+}
+
+func TestGitSubdir(t *testing.T) {
+	InitTestLogging()
+
+	basePath := BuildTestGitRepo("monorepo")
+	defer os.RemoveAll(basePath)
+	repoPath := path.Join(basePath, "monorepo")
+	cmd := NewRunContext(repoPath)
+	for _, data := range [][]string{
+		{"mkdir", "-p", "tools/lib/foo/bar", "tools/other"},
+		{"touch", "tools/lib/foo/foo.go", "tools/lib/foo/bar/bar.go", "tools/other/other.go"},
+		{"git", "add", "tools"},
+		{"git", "commit", "-q", "-m", "add tools"},
+	} {
+		cmd.MustRun(data[0], data[1:]...)
+	}
+
+	dep, err := NewDependency("example.com/foo", "file://"+repoPath, "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	dep.Subdir = "tools/lib/foo"
+	libsrc := &GitSCM{}
+	lib, err := libsrc.Resolve(dep)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(lib.TempDir)
+	if Exists(path.Join(lib.TempDir, "tools/other/other.go")) {
+		t.Errorf("Sparse checkout included 'tools/other'")
+	}
+
+	// only the subdir is installed, under the import path
+	installRoot, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(installRoot)
+	if err := lib.AddDependencies(); err != nil {
+		t.Errorf("%v", err)
+	}
+	if err := lib.Install(installRoot, nil, nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, name := range []string{"foo.go", "bar/bar.go"} {
+		if !Exists(path.Join(installRoot, "example.com/foo", name)) {
+			t.Errorf("Missing '%v' from installed subdir", name)
+		}
+	}
+	if Exists(path.Join(installRoot, "example.com/foo/README")) {
+		t.Errorf("Installed files from outside of the subdir")
+	}
+	if len(lib.Provides) != 1 || lib.Provides[0] != "example.com/foo/bar" {
+		t.Errorf("Bad provides for subdir: %v", lib.Provides)
+	}
+
+	// negative test
+	dep.Subdir = "tools/missing"
+	if lib, err := libsrc.Resolve(dep); err == nil {
+		defer os.RemoveAll(lib.TempDir)
+		if err := lib.AddDependencies(); err == nil {
+			t.Errorf("Missing subdir resolved okay")
+		}
+	}
+}
+
+func TestGitVerifySignature(t *testing.T) {
+	InitTestLogging()
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg is not installed")
+	}
+
+	trusted := BuildTestKeyring("trusted@example.com")
+	defer os.RemoveAll(trusted)
+	defer StopTestKeyring(trusted)
+	untrusted := BuildTestKeyring("untrusted@example.com")
+	defer os.RemoveAll(untrusted)
+	defer StopTestKeyring(untrusted)
+	// holds the signing key, but without trusting it
+	known := ImportTestKey(trusted, "trusted@example.com")
+	defer os.RemoveAll(known)
+	defer StopTestKeyring(known)
+
+	// v1.0 and v1.1 are unsigned; v2.0 is a signed tag on a signed commit
+	basePath := BuildTestGitRepo("gitrepo")
+	defer os.RemoveAll(basePath)
+	repoPath := path.Join(basePath, "gitrepo")
+	cmd := NewRunContext(repoPath)
+	for _, data := range [][]string{
+		{"git", "config", "user.signingkey", "trusted@example.com"},
+		{"env", "GNUPGHOME=" + trusted, "git", "commit", "-q", "-S", "--allow-empty", "-m", "signed"},
+		{"env", "GNUPGHOME=" + trusted, "git", "tag", "-s", "-m", "signed", "v2.0"},
+	} {
+		cmd.MustRun(data[0], data[1:]...)
+	}
+
+	for _, item := range []struct {
+		Version string
+		Verify  int
+		Keyring string
+		Valid   bool
+	}{
+		{"2", VerifySignedTag, trusted, true},
+		{"2", VerifySignedCommit, trusted, true},
+		{"1.1", VerifyNone, trusted, true},
+		{"1.1", VerifySignedTag, trusted, false},
+		{"1.1", VerifySignedCommit, trusted, false},
+		{"2", VerifySignedTag, untrusted, false},
+		{"2", VerifySignedCommit, untrusted, false},
+		{"2", VerifySignedTag, known, false},
+		{"2", VerifySignedCommit, known, false},
+	} {
+		dep, err := NewDependency("foo/bar/baz", "file://"+repoPath, item.Version)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		dep.Verify = item.Verify
+		libsrc := &GitSCM{Keyring: item.Keyring}
+		lib, err := libsrc.Resolve(dep)
+		if lib != nil {
+			defer os.RemoveAll(lib.TempDir)
+		}
+		if item.Valid && err != nil {
+			t.Errorf("Error verifying %v with %v: %v", item.Version, verifyNames[item.Verify], err)
+		} else if !item.Valid && err == nil {
+			t.Errorf("Verified %v with %v against '%v' okay", item.Version, verifyNames[item.Verify], item.Keyring)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	log "grapnel/log"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// Fetches modules from a server that speaks the GOPROXY protocol
+type GoProxySCM struct {
+	BaseUrl string
+	Cache   *Cache // downloads kept between runs; optional
+}
+
+// metadata returned by the '.info' and '@latest' endpoints
+type goProxyInfo struct {
+	Version string
+}
+
+var goProxyClient = func() *http.Client {
+	transport := &http.Transport{}
+	transport.RegisterProtocol("file", http.NewFileTransport(http.Dir("/")))
+	return &http.Client{Transport: transport}
+}()
+
+// Escapes a module path or version for use in a proxy url; upper case
+// letters become '!' followed by the lower case letter.
+func goProxyEscape(value string) string {
+	result := []rune{}
+	for _, ch := range value {
+		if unicode.IsUpper(ch) {
+			result = append(result, '!', unicode.ToLower(ch))
+		} else {
+			result = append(result, ch)
+		}
+	}
+	return string(result)
+}
+
+func (self *GoProxySCM) url(modulePath, endpoint string) string {
+	return strings.TrimSuffix(self.BaseUrl, "/") + "/" + goProxyEscape(modulePath) + "/" + endpoint
+}
+
+func (self *GoProxySCM) get(modulePath, endpoint string) ([]byte, error) {
+	target := self.url(modulePath, endpoint)
+	log.Debug("GET %s", target)
+	response, err := goProxyClient.Get(target)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot contact module proxy: %v", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Module proxy returned '%s' for: '%s'", response.Status, target)
+	}
+	return ioutil.ReadAll(response.Body)
+}
+
+// Fetches version listings and module info, through the cache if there is one.
+func (self *GoProxySCM) getMetadata(modulePath, endpoint string) ([]byte, error) {
+	return self.Cache.Metadata(self.url(modulePath, endpoint), func() ([]byte, error) {
+		return self.get(modulePath, endpoint)
+	})
+}
+
+// Fetches the module zip at 'endpoint'.  With a cache, a copy from an earlier
+// run is used instead, provided it matches the library's sha256 or the cache
+// is offline.
+func (self *GoProxySCM) getZip(lib *Library, modulePath, endpoint string) ([]byte, error) {
+	if self.Cache == nil {
+		return self.get(modulePath, endpoint)
+	}
+	zipUrl := self.url(modulePath, endpoint)
+	cached := self.Cache.ArchivePath(zipUrl)
+	defer self.Cache.Lock(cached)()
+	if !Exists(cached) {
+		if self.Cache.Offline {
+			return nil, self.Cache.Missing("module zip", zipUrl)
+		}
+	} else if self.Cache.Offline {
+		// the caller checks the digest
+		log.Info("Using cached module zip for: '%s'", zipUrl)
+		self.Cache.MarkUsed(cached)
+		return ioutil.ReadFile(cached)
+	} else if lib.Sha256 != "" {
+		if data, err := ioutil.ReadFile(cached); err == nil {
+			sum256 := sha256.Sum256(data)
+			if err := verifyDigest("sha256", lib.Sha256, sum256[:]); err != nil {
+				log.Warn("Discarding cached module zip for '%s': %v", zipUrl, err)
+			} else {
+				log.Info("Using cached module zip for: '%s'", zipUrl)
+				self.Cache.MarkUsed(cached)
+				return data, nil
+			}
+		}
+	}
+
+	data, err := self.get(modulePath, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	file, err := ioutil.TempFile("", "")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(data)
+	file.Close()
+	if err == nil {
+		_, err = self.Cache.StoreArchive(zipUrl, file.Name())
+	}
+	if err != nil {
+		log.Warn("%v", err)
+	}
+	return data, nil
+}
+
+func (self *GoProxySCM) getInfo(modulePath, endpoint string) (*goProxyInfo, error) {
+	data, err := self.getMetadata(modulePath, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	info := &goProxyInfo{}
+	if err := json.Unmarshal(data, info); err != nil {
+		return nil, fmt.Errorf("Bad module info for '%s': %v", modulePath, err)
+	}
+	return info, nil
+}
+
+// Extracts a module zip into 'dest'.  Every entry must be under the
+// 'module@version/' prefix, which is stripped off.
+func extractModuleZip(dest string, data []byte, prefix string) error {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return fmt.Errorf("Cannot read module zip: %v", err)
+	}
+	for _, file := range reader.File {
+		if !strings.HasPrefix(file.Name, prefix) {
+			return fmt.Errorf("Module zip entry outside of '%s': '%s'", prefix, file.Name)
+		}
+	}
+	return extractZip(dest, reader, strings.Count(prefix, "/"))
+}
+
+func (self *GoProxySCM) Resolve(dep *Dependency) (*Library, error) {
+	lib := NewLibrary(dep)
+
+	if self.BaseUrl == "" {
+		return nil, fmt.Errorf("No module proxy configured for dependency: '%s'", lib.Import)
+	}
+	modulePath := lib.Import
+
+	log.Info("Fetching Go Proxy Dependency: '%s'", lib.Import)
+
+	// figure out which version to fetch; a tag pins an exact version
+	if lib.Tag != "" {
+		// a pinned tag still has to satisfy the version spec
+		ver, err := ParseVersion(lib.Tag)
+		if !lib.VersionSpec.IsUnversioned() && (err != nil || !lib.VersionSpec.IsSatisfiedBy(ver)) {
+			return nil, fmt.Errorf("Tag '%s' does not satisfy version specification: %v.", lib.Tag, lib.VersionSpec)
+		}
+		if err == nil {
+			lib.Version = ver
+		} else {
+			lib.Version = NewVersion(-1, -1, -1)
+		}
+	} else if lib.VersionSpec.IsUnversioned() {
+		info, err := self.getInfo(modulePath, "@latest")
+		if err != nil {
+			return nil, err
+		}
+		lib.Tag = info.Version
+		lib.Version = NewVersion(-1, -1, -1)
+	} else {
+		data, err := self.getMetadata(modulePath, "@v/list")
+		if err != nil {
+			return nil, err
+		}
+		tags := []string{}
+		for _, line := range strings.Split(string(data), "\n") {
+			tags = append(tags, strings.TrimSpace(line))
+		}
+		if lib.Tag, lib.Version, err = SelectTag(lib.VersionSpec, lib.Strategy, tags); err != nil {
+			return nil, err
+		}
+	}
+
+	// confirm the version with the proxy's metadata
+	escapedVersion := goProxyEscape(lib.Tag)
+	if info, err := self.getInfo(modulePath, "@v/"+escapedVersion+".info"); err != nil {
+		return nil, err
+	} else if info.Version != lib.Tag {
+		return nil, fmt.Errorf("Module proxy reported version '%s' for '%s'", info.Version, lib.Tag)
+	}
+
+	// create a dedicated directory
+	tempRoot, err := ioutil.TempDir("", "")
+	if err != nil {
+		return nil, err
+	}
+	lib.TempDir = tempRoot
+
+	// download and extract the module content
+	log.Info("Fetching remote data for %s", lib.Import)
+	data, err := self.getZip(lib, modulePath, "@v/"+escapedVersion+".zip")
+	if err != nil {
+		return nil, err
+	}
+	sum256 := sha256.Sum256(data)
+	if err := verifyDigest("sha256", lib.Sha256, sum256[:]); err != nil {
+		return nil, fmt.Errorf("While verifying module '%s': %v", modulePath, err)
+	}
+	lib.Sha256 = hex.EncodeToString(sum256[:])
+	prefix := modulePath + "@" + lib.Tag + "/"
+	if err := extractModuleZip(tempRoot, data, prefix); err != nil {
+		return nil, err
+	}
+	lib.ArchiveUrl = self.url(modulePath, "@v/"+escapedVersion+".zip")
+	lib.ArchiveStrip = strings.Count(prefix, "/")
+
+	if lib.Version.Major == -1 {
+		log.Warn("Resolved: %v (unversioned)", lib.Import)
+	} else {
+		log.Info("Resolved: %s %v", lib.Import, lib.Version)
+	}
+	return lib, nil
+}
+
+func (self *GoProxySCM) ToDSD(lib *Library) string {
+	return archiveDSD(lib)
+}
@@ -0,0 +1,125 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"archive/zip"
+	"bytes"
+	. "grapnel/testing"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestGoProxyEscape(t *testing.T) {
+	for value, expected := range map[string]string{
+		"github.com/foo/bar":         "github.com/foo/bar",
+		"github.com/Azure/azure-sdk": "github.com/!azure/azure-sdk",
+		"v1.0.0-RC1":                 "v1.0.0-!r!c1",
+	} {
+		if result := goProxyEscape(value); result != expected {
+			t.Errorf("'%v' escaped to '%v', expected '%v'", value, result, expected)
+		}
+	}
+}
+
+func TestGoProxySource(t *testing.T) {
+	InitTestLogging()
+
+	baseUrl := BuildTestGoProxy("example.com/foo/bar", "v1.0.0", "v1.1.0", "v1.2.0", "v2.0.0")
+	defer os.RemoveAll(strings.TrimPrefix(baseUrl, "file://"))
+	libsrc := &GoProxySCM{BaseUrl: baseUrl}
+
+	for _, item := range []struct {
+		Version  string
+		Tag      string
+		Strategy int
+		Result   string
+	}{
+		{"1.*", "", StrategyNewest, "v1.2.0"},
+		{">=1.1", "", StrategyMinimal, "v1.1.0"},
+		{"", "v1.0.0", StrategyNewest, "v1.0.0"},
+		{"1.*", "v1.1.0", StrategyNewest, "v1.1.0"},
+	} {
+		dep, err := NewDependency("example.com/foo/bar", "", item.Version)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		dep.Tag = item.Tag
+		dep.Strategy = item.Strategy
+		lib, err := libsrc.Resolve(dep)
+		if err != nil {
+			t.Errorf("Error resolving '%v': %v", item.Version, err)
+			continue
+		}
+		defer os.RemoveAll(lib.TempDir)
+		if lib.Tag != item.Result {
+			t.Errorf("'%v' resolved to '%v', expected '%v'", item.Version, lib.Tag, item.Result)
+		}
+		if data, err := ioutil.ReadFile(path.Join(lib.TempDir, "README")); err != nil {
+			t.Errorf("Error reading module content: %v", err)
+		} else if string(data) != item.Result {
+			t.Errorf("Module content is for '%s', expected '%v'", data, item.Result)
+		}
+	}
+
+	// negative tests
+	dep, _ := NewDependency("example.com/foo/bar", "", "3.0")
+	if _, err := libsrc.Resolve(dep); err == nil {
+		t.Errorf("Missing version resolved okay")
+	}
+	dep, _ = NewDependency("example.com/foo/bar", "", "1.*")
+	dep.Tag = "v2.0.0"
+	if _, err := libsrc.Resolve(dep); err == nil {
+		t.Errorf("Tag outside of the version spec resolved okay")
+	}
+	dep, _ = NewDependency("example.com/foo/missing", "", "1.0")
+	if _, err := libsrc.Resolve(dep); err == nil {
+		t.Errorf("Missing module resolved okay")
+	}
+}
+
+func TestExtractModuleZipRejectsEscapes(t *testing.T) {
+	for _, name := range []string{
+		"example.com/foo@v1.0.0/../../evil.txt",
+		"example.com/other@v1.0.0/README",
+	} {
+		buffer := &bytes.Buffer{}
+		writer := zip.NewWriter(buffer)
+		if _, err := writer.Create(name); err != nil {
+			t.Fatalf("%v", err)
+		}
+		writer.Close()
+
+		dest, err := ioutil.TempDir("", "")
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		defer os.RemoveAll(dest)
+		if err := extractModuleZip(dest, buffer.Bytes(), "example.com/foo@v1.0.0/"); err == nil {
+			t.Errorf("Zip entry '%v' extracted okay", name)
+		}
+	}
+}
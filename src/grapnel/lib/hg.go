@@ -0,0 +1,151 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"fmt"
+	log "grapnel/log"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+var HgRewriteRules = RewriteRuleArray{
+	// rewrite rules for misc mercurial resolvers
+	TypeResolverRule("scheme", `^hg$`, `hg`),
+	TypeResolverRule("host", `^hg\.`, `hg`),
+	TypeResolverRule("host", `^code\.google\.com$`, `hg`),
+
+	// 'hg' is not a real protocol; fetch over https instead
+	BuildRewriteRule(StringMap{
+		"type":   `hg`,
+		"scheme": `^hg$`,
+	}, StringMap{
+		"scheme": `https`,
+	}),
+	// support for code.google.com/p projects
+	BuildRewriteRule(StringMap{
+		"type": `hg`,
+		"host": `^code\.google\.com$`,
+		"path": `^/p/`,
+	}, StringMap{
+		"path":   `{{ replace .path "^/p/([^/]*).*$" "/p/$1" }}`,
+		"import": `{{ replace .import "^code.google.com/p/([^/]*).*$" "code.google.com/p/$1" }}`,
+	}),
+}
+
+type HgSCM struct{}
+
+func stripHgRepo(baseDir string) {
+	os.RemoveAll(path.Join(baseDir, ".hg"))
+}
+
+func (self *HgSCM) Resolve(dep *Dependency) (*Library, error) {
+	lib := NewLibrary(dep)
+
+	// fix the tag, and default branch
+	pinned := lib.Tag != ""
+	if lib.Branch == "" {
+		lib.Branch = "default"
+	}
+	if lib.Tag == "" {
+		lib.Tag = lib.Branch // tip of the branch
+	}
+	if lib.Url == nil {
+		return nil, fmt.Errorf("Cannot download hg dependency without a url: '%s'", lib.Import)
+	}
+
+	log.Info("Fetching Hg Dependency: '%s'", lib.Import)
+
+	// create a dedicated directory and a context for commands
+	tempRoot, err := ioutil.TempDir("", "")
+	if err != nil {
+		return nil, err
+	}
+	lib.TempDir = tempRoot
+	cmd := NewRunContext(tempRoot)
+
+	// get the entire repository without a working copy
+	log.Info("Fetching remote data for %s", lib.Import)
+	if err := cmd.Run("hg", "clone", "--noupdate", lib.Url.String(), tempRoot); err != nil {
+		return nil, fmt.Errorf("Cannot download dependency: '%s'", lib.Url.String())
+	}
+
+	// pick a tag by version, unless the dependency names one
+	if lib.VersionSpec.IsUnversioned() {
+		lib.Version = NewVersion(-1, -1, -1)
+	} else if !pinned {
+		if err := cmd.Run("hg", "tags", "--quiet"); err != nil {
+			return nil, fmt.Errorf("Failed to acquire tag list for depenency")
+		}
+		tags := []string{}
+		for _, line := range strings.Split(cmd.CombinedOutput, "\n") {
+			tags = append(tags, strings.TrimSpace(line))
+		}
+		if lib.Tag, lib.Version, err = SelectTag(lib.VersionSpec, lib.Strategy, tags); err != nil {
+			return nil, err
+		}
+	}
+
+	// move to the specified branch/tag/revision
+	if err := cmd.Run("hg", "update", "--clean", "--rev", lib.Tag); err != nil {
+		return nil, fmt.Errorf("Failed to update to revision: '%s'", lib.Tag)
+	}
+
+	// pin the Tag to the full changeset ID
+	if err := cmd.Run("hg", "log", "--rev", ".", "--template", "{node}"); err != nil {
+		return nil, fmt.Errorf("Failed to identify revision: '%s'", lib.Tag)
+	}
+	pinnedTag := lib.Tag
+	lib.Tag = strings.TrimSpace(cmd.CombinedOutput)
+
+	// a tag given up front still has to satisfy the version spec, by way of
+	// a version tag on the same changeset
+	if lib.Version == nil {
+		if err := cmd.Run("hg", "log", "--rev", ".", "--template", "{join(tags, '\\n')}"); err != nil {
+			return nil, fmt.Errorf("Failed to acquire tag list for depenency")
+		}
+		tags := strings.Split(cmd.CombinedOutput, "\n")
+		if _, lib.Version, err = SelectTag(lib.VersionSpec, lib.Strategy, tags); err != nil {
+			return nil, fmt.Errorf("Tag '%s' does not satisfy version specification: %v.", pinnedTag, lib.VersionSpec)
+		}
+	}
+
+	if lib.Version.Major == -1 {
+		log.Warn("Resolved: %v (unversioned)", lib.Import)
+	} else {
+		log.Info("Resolved: %s %v", lib.Import, lib.Version)
+	}
+	stripHgRepo(lib.TempDir)
+	return lib, nil
+}
+
+func (self *HgSCM) ToDSD(lib *Library) string {
+	return dsdLines(
+		dsdCommand("hg", "clone", "--quiet", "--noupdate", lib.Url.String(), "$dir"),
+		dsdCommand("hg", "--repository", "$dir", "update", "--quiet", "--clean", "--rev", lib.Tag),
+		dsdCommand("check_equal", "changeset", lib.Tag,
+			`$(hg --repository "$dir" log --rev . --template '{node}')`),
+		dsdCommand("rm", "-rf", "$dir/.hg"))
+}
@@ -0,0 +1,128 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	. "grapnel/testing"
+	url "grapnel/url"
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+)
+
+func TestHgRewrite(t *testing.T) {
+	rules := RewriteRuleArray{}
+	rules = append(rules, BasicRewriteRules...)
+	rules = append(rules, GitRewriteRules...)
+	rules = append(rules, HgRewriteRules...)
+
+	for _, test := range []struct {
+		Src *Dependency
+		Dst *Dependency
+	}{
+		{
+			Src: &Dependency{
+				Url: url.MustParse("hg://hg.example.com/foo"),
+			},
+			Dst: &Dependency{
+				Import: "hg.example.com//foo",
+				Url:    url.MustParse("https://hg.example.com/foo"),
+				Type:   "hg",
+			},
+		}, {
+			Src: &Dependency{
+				Import: "code.google.com/p/go.net/html",
+			},
+			Dst: &Dependency{
+				Import: "code.google.com/p/go.net",
+				Url:    url.MustParse("http://code.google.com/p/go.net"),
+				Type:   "hg",
+			},
+		},
+	} {
+		if err := rules.Apply(test.Src); err != nil {
+			t.Errorf("Error during replacement %v; Src: %v", err, test.Src.Flatten())
+		}
+		if !test.Src.Equal(test.Dst) {
+			t.Errorf("Error during replacement Src: %#v; Dst: %#v",
+				test.Src.Flatten(), test.Dst.Flatten())
+		}
+	}
+}
+
+func TestHgSource(t *testing.T) {
+	if _, err := exec.LookPath("hg"); err != nil {
+		t.Skip("hg is not installed")
+	}
+	InitTestLogging()
+
+	// construct a repo
+	basePath := BuildTestHgRepo("hgrepo")
+	defer os.RemoveAll(basePath)
+
+	// start a server for the repo
+	defer StopHgServe()
+	if err := StartHgServe(path.Join(basePath, "hgrepo")); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// map a dependency to the repo
+	dep, err := NewDependency("foo/bar/baz", "http://localhost:9998/", "1.0")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// test the resolver
+	libsrc := &HgSCM{}
+	lib, err := libsrc.Resolve(dep)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(lib.TempDir)
+	if len(lib.Tag) != 40 {
+		t.Errorf("Expected a pinned changeset ID; got '%v' instead", lib.Tag)
+	}
+	if Exists(path.Join(lib.TempDir, "foo.txt")) {
+		t.Errorf("Expected v1.0 to be checked out")
+	}
+
+	// a pinned changeset is kept, rather than the newest matching tag
+	pinned, _ := NewDependency("foo/bar/baz", "http://localhost:9998/", "1.*")
+	pinned.Tag = lib.Tag
+	if relib, err := libsrc.Resolve(pinned); err != nil {
+		t.Errorf("Error resolving pinned changeset: %v", err)
+	} else {
+		defer os.RemoveAll(relib.TempDir)
+		if relib.Tag != lib.Tag || relib.Version.String() != lib.Version.String() {
+			t.Errorf("Pinned changeset %v resolved to %v (%v)", lib.Tag, relib.Tag, relib.Version)
+		}
+	}
+
+	// ... and has to satisfy the version spec
+	pinned.VersionSpec, _ = ParseVersionSpec("1.1")
+	if relib, err := libsrc.Resolve(pinned); err == nil {
+		defer os.RemoveAll(relib.TempDir)
+		t.Errorf("Pinned changeset resolved okay against the wrong version")
+	}
+}
@@ -24,12 +24,16 @@ THE SOFTWARE.
 
 import (
 	"fmt"
+	toml "github.com/pelletier/go-toml"
 	"go/build"
 	log "grapnel/log"
 	"io"
+	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
+	"strings"
 )
 
 // contains resolved factors from the parent depdendency specification
@@ -39,6 +43,11 @@ type Library struct {
 	TempDir      string
 	Provides     []string // imports provided by this library
 	Dependencies []*Dependency
+
+	// details of the fetch, for sources that have them; used for DSD scripts
+	Commit       string // commit that was checked out
+	ArchiveUrl   string // archive that was downloaded
+	ArchiveStrip int    // leading path components dropped from the archive
 }
 
 func NewLibrary(dep *Dependency) *Library {
@@ -50,17 +59,105 @@ func NewLibrary(dep *Dependency) *Library {
 	return result
 }
 
-func (self *Library) Install(installRoot string) error {
-	// set up root target dir
+// Returns the directory that holds the library's content.  This is only a
+// part of TempDir when the dependency names a 'subdir'.
+func (self *Library) Root() string {
+	return filepath.Join(self.TempDir, self.Subdir)
+}
+
+// Installs the library under 'installRoot'.  With a store, the tree is added
+// to the store and the install is made of hardlinks to it; otherwise the
+// files are copied.  The tree must match the TreeHash from the lockfile, if
+// there is one, and TreeHash is set to the installed tree either way.
+//
+// The tree is built next to the old install and renamed into place, so that
+// nothing from an older version is left behind.  'nested' are the imports of
+// other libraries installed inside of this one, which are carried over.
+func (self *Library) Install(installRoot string, store *Store, nested []string) error {
 	importPath := filepath.Join(installRoot, self.Import)
 	log.Debug("installing to: %s", importPath)
-	if err := os.MkdirAll(importPath, 0755); err != nil {
+
+	// check the tree before anything is installed
+	var hash string
+	var err error
+	if store != nil {
+		hash, err = store.Add(self.Root())
+	} else {
+		hash, err = TreeHash(self.Root())
+	}
+	if err != nil {
+		return fmt.Errorf("Cannot hash the tree of '%s': %v", self.Import, err)
+	}
+	if self.TreeHash != "" && self.TreeHash != hash {
+		return fmt.Errorf("Tree hash mismatch for '%s': expected %s, got %s", self.Import, self.TreeHash, hash)
+	}
+	self.TreeHash = hash
+
+	// set up a fresh target dir beside the old one
+	if err := os.MkdirAll(filepath.Dir(importPath), 0755); err != nil {
 		log.Info("%s", err.Error())
 		return fmt.Errorf("Could not create target directory: '%s'", importPath)
 	}
+	newPath, err := ioutil.TempDir(filepath.Dir(importPath), "."+filepath.Base(importPath)+".")
+	if err != nil {
+		return fmt.Errorf("Could not create target directory: %v", err)
+	}
+	if err := self.installTree(newPath, store, hash); err != nil {
+		os.RemoveAll(newPath)
+		return err
+	}
+
+	// libraries inside of this one take the place of anything at their path
+	for _, other := range nested {
+		relativePath := strings.TrimPrefix(other, self.Import+"/")
+		oldPath := filepath.Join(importPath, relativePath)
+		if !Exists(oldPath) {
+			continue
+		}
+		movedPath := filepath.Join(newPath, relativePath)
+		os.RemoveAll(movedPath)
+		if err := os.MkdirAll(filepath.Dir(movedPath), 0755); err == nil {
+			err = os.Rename(oldPath, movedPath)
+		}
+		if err != nil {
+			os.RemoveAll(newPath)
+			return fmt.Errorf("Cannot keep nested library '%s': %v", other, err)
+		}
+	}
+
+	// swap the new tree in for the old one
+	oldPath := newPath + ".old"
+	if Exists(importPath) {
+		if err := os.Rename(importPath, oldPath); err != nil {
+			os.RemoveAll(newPath)
+			return fmt.Errorf("Cannot replace '%s': %v", importPath, err)
+		}
+	}
+	if err := os.Rename(newPath, importPath); err != nil {
+		os.Rename(oldPath, importPath)
+		os.RemoveAll(newPath)
+		return fmt.Errorf("Cannot replace '%s': %v", importPath, err)
+	}
+	os.RemoveAll(oldPath)
+	return nil
+}
+
+// Fills 'dest' with the library's tree: linked from the store entry for
+// 'hash', or copied when there is no store.
+func (self *Library) installTree(dest string, store *Store, hash string) error {
+	if err := os.Chmod(dest, 0755); err != nil {
+		return err
+	}
+	if store != nil {
+		if err := LinkFileTree(dest, store.Path(hash)); err != nil {
+			log.Info("%s", err.Error())
+			return fmt.Errorf("Error while linking dependency file tree")
+		}
+		return nil
+	}
 
 	// move everything over
-	if err := CopyFileTree(importPath, self.TempDir); err != nil {
+	if err := CopyFileTree(dest, self.Root()); err != nil {
 		log.Info("%s", err.Error())
 		return fmt.Errorf("Error while walking dependency file tree")
 	}
@@ -75,11 +172,19 @@ func (self *Library) AddDependencies() error {
 	if self.TempDir == "" {
 		return nil // do nothing if there's nothing to search
 	}
+	root := self.Root()
+	if !Exists(root) {
+		return fmt.Errorf("Cannot find subdir '%s' in dependency: '%s'", self.Subdir, self.Import)
+	}
 
 	// get dependencies via lockfile or grapnelfile
-	if deplist, err := LoadGrapnelDepsfile(
-		path.Join(self.TempDir, "grapnel-lock.toml"),
-		path.Join(self.TempDir, "grapnel.toml")); err != nil {
+	if lockfile, err := LoadLockfile(path.Join(root, "grapnel-lock.toml")); err != nil {
+		return err
+	} else if lockfile != nil {
+		self.Dependencies = append(self.Dependencies, lockfile.Dependencies...)
+		return nil
+	}
+	if deplist, err := LoadGrapnelDepsfile(path.Join(root, "grapnel.toml")); err != nil {
 		return err
 	} else if deplist != nil {
 		self.Dependencies = append(self.Dependencies, deplist...)
@@ -87,7 +192,7 @@ func (self *Library) AddDependencies() error {
 	}
 
 	// figure out the provided modules in this library
-	if importPaths, err := GetDirectories(self.TempDir); err != nil {
+	if importPaths, err := GetDirectories(root); err != nil {
 		return err
 	} else {
 		// fully qualify the set of paths
@@ -97,7 +202,7 @@ func (self *Library) AddDependencies() error {
 	}
 
 	// attempt get dependencies via raw import statements instead
-	pkg, err := build.ImportDir(self.TempDir, 0)
+	pkg, err := build.ImportDir(root, 0)
 	if err != nil {
 		log.Debug("Failed to get go imports for %v", err)
 		log.Warn("No Go imports to process for %v", self.Import)
@@ -120,34 +225,93 @@ func (self *Library) AddDependencies() error {
 	return nil
 }
 
-func (self *Library) ToToml(writer io.Writer) {
-	fmt.Fprintf(writer, "\n[[dependencies]]\n")
-	if self.Version.Major > 0 {
-		fmt.Fprintf(writer, "version = \"%v\"\n", self.Version)
-	} else {
-		fmt.Fprintf(writer, "# Unversioned\n")
+// Builds the lockfile entry for the library.
+func (self *Library) ToTomlTree() *toml.TomlTree {
+	values := map[string]interface{}{}
+	if self.Version != nil && self.Version.Major >= 0 {
+		values["version"] = self.Version.String()
 	}
-	if self.Type != "" {
-		fmt.Fprintf(writer, "type = \"%s\"\n", self.Type)
+	for key, value := range map[string]string{
+		"type":         self.Type,
+		"import":       self.Import,
+		"url":          self.urlString(),
+		"branch":       self.Branch,
+		"subdir":       self.Subdir,
+		"tag":          self.Tag,
+		"url_template": self.UrlTemplate,
+		"index":        self.Index,
+		"asset":        self.Asset,
+		"sha256":       self.Sha256,
+		"sha512":       self.Sha512,
+		"tree_hash":    self.TreeHash,
+	} {
+		if value != "" {
+			values[key] = value
+		}
 	}
-	if self.Import != "" {
-		fmt.Fprintf(writer, "import = \"%s\"\n", self.Import)
+	if self.StripComponents > 0 {
+		values["strip_components"] = int64(self.StripComponents)
 	}
-	if self.Url != nil {
-		fmt.Fprintf(writer, "url = \"%s\"\n", self.Url.String())
+	if self.Verify != VerifyNone {
+		values["verify"] = verifyNames[self.Verify]
 	}
-	if self.Branch != "" {
-		fmt.Fprintf(writer, "branch = \"%s\"\n", self.Branch)
+	switch self.Submodules {
+	case SubmodulesTop:
+		values["submodules"] = true
+	case SubmodulesRecursive:
+		values["submodules"] = "recursive"
 	}
-	if self.Tag != "" {
-		// TODO: repair notification
-		//if self.Dependency.Tag == "" && self.Version.Major == 0 {
-		//  fmt.Fprintf(writer, "# Pinned to recent tip/head of repository\n")
-		//}
-		fmt.Fprintf(writer, "tag = \"%s\"\n", self.Tag)
+	if len(self.SubmoduleCommits) > 0 {
+		paths := []string{}
+		for subPath := range self.SubmoduleCommits {
+			paths = append(paths, subPath)
+		}
+		sort.Strings(paths)
+		pins := []interface{}{}
+		for _, subPath := range paths {
+			pins = append(pins, subPath+"="+self.SubmoduleCommits[subPath])
+		}
+		values["submodule_commits"] = pins
+	}
+	if self.RequestedImport != "" {
+		values["requested_import"] = self.RequestedImport
+	}
+	if self.RequestedVersion != "" {
+		values["requested_version"] = self.RequestedVersion
+	}
+	if len(self.RequiredBy) > 0 {
+		names := []interface{}{}
+		for _, name := range self.RequiredBy {
+			names = append(names, name)
+		}
+		values["required_by"] = names
+	}
+	return toml.TreeFromMap(values)
+}
+
+// Writes the library as a lockfile document with just the one entry.
+func (self *Library) ToToml(writer io.Writer) {
+	writer.Write(marshalToml(toml.TreeFromMap(map[string]interface{}{
+		"dependencies": []*toml.TomlTree{self.ToTomlTree()},
+	})))
+}
+
+func (self *Library) urlString() string {
+	if self.Url == nil {
+		return ""
 	}
+	return self.Url.String()
 }
 
-func (self *Library) ToDsd(writer io.Writer) {
-	//TODO
+// Writes the DSD script entry for this library: 'fetch' are the commands that
+// get the library into '$dir', as written by its LibSource.
+func (self *Library) ToDsd(writer io.Writer, fetch string) {
+	version := "unversioned"
+	if self.Version.Major >= 0 {
+		version = self.Version.String()
+	}
+	fmt.Fprintf(writer, "\n# %s (%s)\n", self.Import, version)
+	fmt.Fprintf(writer, "dir=\"$(mktemp -d \"$work/lib.XXXXXX\")\"\n")
+	fmt.Fprint(writer, fetch)
+	fmt.Fprint(writer, dsdLines(dsdCommand("install_lib", "$dir", self.Import, self.Subdir)))
 }
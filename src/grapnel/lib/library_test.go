@@ -23,6 +23,9 @@ THE SOFTWARE.
 */
 
 import (
+	"bytes"
+	toml "github.com/pelletier/go-toml"
+	"reflect"
 	"testing"
 )
 
@@ -45,3 +48,117 @@ func TestNewLibrary(t *testing.T) {
 			lib.Url.String(), "http://github.com/foo/bar")
 	}
 }
+
+func TestLibrarySubmodulesToToml(t *testing.T) {
+	for _, submodules := range []int{SubmodulesNone, SubmodulesTop, SubmodulesRecursive} {
+		dep, _ := NewDependency("foo/bar/baz", "http://github.com/foo/bar", "")
+		lib := NewLibrary(dep)
+		lib.Version = NewVersion(-1, -1, -1)
+		lib.Submodules = submodules
+		if submodules != SubmodulesNone {
+			lib.SubmoduleCommits = map[string]string{
+				"vendor/b":        "2222",
+				"vendor/a":        "1111",
+				"vendor/a/nested": "3333",
+			}
+		}
+
+		// write the library out and read it back in
+		buffer := &bytes.Buffer{}
+		lib.ToToml(buffer)
+		tree, err := toml.Load(buffer.String())
+		if err != nil {
+			t.Errorf("Error parsing TOML data: %v\n%v", err, buffer.String())
+			continue
+		}
+		result, err := NewDependencyFromToml(tree.Get("dependencies").([]*toml.TomlTree)[0])
+		if err != nil {
+			t.Errorf("Error building dependency from TOML: %v", err)
+			continue
+		}
+		if result.Submodules != lib.Submodules ||
+			!reflect.DeepEqual(result.SubmoduleCommits, lib.SubmoduleCommits) {
+			t.Errorf("Submodules %v did not survive the lockfile: %v", submodules, buffer.String())
+		}
+	}
+
+	// negative tests
+	for _, entry := range []string{
+		`submodules = "yes"`,
+		`submodules = 1`,
+		`submodule_commits = ["vendor/a"]`,
+		`submodule_commits = "vendor/a=1111"`,
+	} {
+		tree, _ := toml.Load("import = \"foo/bar/baz\"\n" + entry)
+		if _, err := NewDependencyFromToml(tree); err == nil {
+			t.Errorf("Bad entry parsed okay: %v", entry)
+		}
+	}
+}
+
+func TestLibraryVersionToToml(t *testing.T) {
+	for _, item := range []struct {
+		Version  *Version
+		Expected interface{}
+	}{
+		{NewVersion(1, 2, 5), "1.2.5"},
+		{NewVersion(0, 3, 1), "0.3.1"},
+		{NewVersion(0, 0, 1), "0.0.1"},
+		{NewVersion(-1, -1, -1), nil},
+	} {
+		dep, _ := NewDependency("foo/bar/baz", "http://github.com/foo/bar", "")
+		lib := NewLibrary(dep)
+		lib.Version = item.Version
+
+		buffer := &bytes.Buffer{}
+		lib.ToToml(buffer)
+		tree, err := toml.Load(buffer.String())
+		if err != nil {
+			t.Errorf("Error parsing TOML data: %v\n%v", err, buffer.String())
+			continue
+		}
+		entry := tree.Get("dependencies").([]*toml.TomlTree)[0]
+		if version := entry.Get("version"); version != item.Expected {
+			t.Errorf("Bad version for %v: %v. Expected: %v", item.Version, version, item.Expected)
+		}
+	}
+}
+
+func TestLibraryGraphToToml(t *testing.T) {
+	dep, _ := NewDependency("foo/bar/baz", "http://github.com/foo/bar", "1.2")
+	dep.RequestedImport = "example.com/baz"
+	dep.AddRequiredBy("foo/gorf", "foo/bar/qux")
+	lib := NewLibrary(dep)
+	lib.Version = NewVersion(1, 2, 5)
+
+	// write the library out and read it back in
+	buffer := &bytes.Buffer{}
+	lib.ToToml(buffer)
+	tree, err := toml.Load(buffer.String())
+	if err != nil {
+		t.Errorf("Error parsing TOML data: %v\n%v", err, buffer.String())
+		return
+	}
+	result, err := NewDependencyFromToml(tree.Get("dependencies").([]*toml.TomlTree)[0])
+	if err != nil {
+		t.Errorf("Error building dependency from TOML: %v", err)
+		return
+	}
+	if result.RequestedImport != "example.com/baz" ||
+		result.RequestedVersion != "1.2" ||
+		!reflect.DeepEqual(result.RequiredBy, []string{"foo/bar/qux", "foo/gorf"}) {
+		t.Errorf("Graph details did not survive the lockfile: %v", buffer.String())
+	}
+
+	// negative tests
+	for _, entry := range []string{
+		`required_by = "foo/gorf"`,
+		`required_by = [1]`,
+		`required_by = [""]`,
+	} {
+		tree, _ := toml.Load("import = \"foo/bar/baz\"\n" + entry)
+		if _, err := NewDependencyFromToml(tree); err == nil {
+			t.Errorf("Bad entry parsed okay: %v", entry)
+		}
+	}
+}
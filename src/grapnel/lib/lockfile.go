@@ -0,0 +1,238 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"bytes"
+	"fmt"
+	toml "github.com/pelletier/go-toml"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+)
+
+// The lockfile format written by this version of grapnel.  Lockfiles without
+// a [metadata] table predate versioning, and are read as version 1.
+const LockVersion = 2
+
+// Migrations from each lockfile version to the next, indexed by the version
+// they upgrade from.
+var lockMigrations = []func(*Lockfile) error{
+	nil, // there is no version 0
+	migrateLockV1,
+}
+
+// Version 1 lockfiles are a flat list of pinned dependencies.  Anything they
+// say about the graph predates the format, so it is dropped, and install
+// scans sources for dependencies as it always has.
+func migrateLockV1(lockfile *Lockfile) error {
+	for _, dep := range lockfile.Dependencies {
+		dep.RequestedImport = ""
+		dep.RequestedVersion = ""
+		dep.RequiredBy = nil
+	}
+	return nil
+}
+
+// Lockfile keys in the order they are written, so that lockfiles diff
+// cleanly.  Any other keys follow, sorted.
+var lockKeyOrder = []string{
+	"metadata", "lock_version", "dependencies",
+	"version", "type", "import", "url", "branch", "subdir", "tag",
+	"url_template", "index", "asset", "sha256", "sha512", "tree_hash",
+	"strip_components", "verify", "submodules", "submodule_commits",
+	"requested_import", "requested_version", "required_by",
+}
+
+type tomlKeysByLockOrder []string
+
+func (self tomlKeysByLockOrder) Len() int      { return len(self) }
+func (self tomlKeysByLockOrder) Swap(i, j int) { self[i], self[j] = self[j], self[i] }
+func (self tomlKeysByLockOrder) Less(i, j int) bool {
+	rank := func(key string) int {
+		for ii, name := range lockKeyOrder {
+			if name == key {
+				return ii
+			}
+		}
+		return len(lockKeyOrder)
+	}
+	if rank(self[i]) != rank(self[j]) {
+		return rank(self[i]) < rank(self[j])
+	}
+	return self[i] < self[j]
+}
+
+// Encodes 'tree' as a TOML document.  go-toml writes keys in map order, so
+// this walks the tree in lockfile order instead, and leaves the encoding of
+// values to go-toml.  Values come before tables, as TOML requires.
+func marshalToml(tree *toml.TomlTree) []byte {
+	writer := &bytes.Buffer{}
+	marshalTomlTree(writer, tree, "")
+	return bytes.TrimLeft(writer.Bytes(), "\n")
+}
+
+func marshalTomlTree(writer *bytes.Buffer, tree *toml.TomlTree, keyspace string) {
+	keys := tree.Keys()
+	sort.Sort(tomlKeysByLockOrder(keys))
+	tables := []string{}
+	for _, key := range keys {
+		switch tree.Get(key).(type) {
+		case *toml.TomlTree, []*toml.TomlTree:
+			tables = append(tables, key)
+		default:
+			writer.WriteString(toml.TreeFromMap(map[string]interface{}{
+				key: tree.Get(key),
+			}).ToString())
+		}
+	}
+	for _, key := range tables {
+		name := key
+		if keyspace != "" {
+			name = keyspace + "." + key
+		}
+		switch value := tree.Get(key).(type) {
+		case *toml.TomlTree:
+			fmt.Fprintf(writer, "\n[%s]\n", name)
+			marshalTomlTree(writer, value, name)
+		case []*toml.TomlTree:
+			for _, item := range value {
+				fmt.Fprintf(writer, "\n[[%s]]\n", name)
+				marshalTomlTree(writer, item, name)
+			}
+		}
+	}
+}
+
+type Lockfile struct {
+	Version      int // lock_version of the file, before any migration
+	Dependencies []*Dependency
+}
+
+// Loads a lockfile, and migrates it in memory to the current LockVersion.
+// Returns nil if the file doesn't exist.
+func LoadLockfile(filename string) (*Lockfile, error) {
+	if !Exists(filename) {
+		return nil, nil
+	}
+	tree, err := toml.LoadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s", filename, err)
+	}
+
+	lockfile := &Lockfile{Version: 1, Dependencies: []*Dependency{}}
+	if tree.Has("metadata.lock_version") {
+		version, ok := tree.Get("metadata.lock_version").(int64)
+		if !ok || version < 1 {
+			return nil, fmt.Errorf("%s: 'lock_version' must be a positive integer", filename)
+		}
+		lockfile.Version = int(version)
+	}
+	if lockfile.Version > LockVersion {
+		return nil, fmt.Errorf("%s is lock_version %d, but this grapnel only reads up to %d; please upgrade grapnel",
+			filename, lockfile.Version, LockVersion)
+	}
+
+	items, ok := tree.GetDefault("dependencies", []*toml.TomlTree{}).([]*toml.TomlTree)
+	if !ok {
+		return nil, fmt.Errorf("%s: 'dependencies' must be an array of tables", filename)
+	}
+	for idx, item := range items {
+		dep, err := NewDependencyFromToml(item)
+		if err != nil {
+			return nil, fmt.Errorf("In dependency #%d: %v", idx, err)
+		}
+		// the version of an entry is the pinned one, not what was requested
+		dep.RequestedVersion = item.GetDefault("requested_version", "").(string)
+		lockfile.Dependencies = append(lockfile.Dependencies, dep)
+	}
+
+	for version := lockfile.Version; version < LockVersion; version++ {
+		if err := lockMigrations[version](lockfile); err != nil {
+			return nil, fmt.Errorf("%s: cannot migrate from lock_version %d: %v", filename, version, err)
+		}
+	}
+	return lockfile, nil
+}
+
+// Rebuilds the libraries the lockfile was written from, without fetching
+// them, so that they can be written out again.
+func (self *Lockfile) Libraries() []*Library {
+	libs := []*Library{}
+	for _, dep := range self.Dependencies {
+		lib := NewLibrary(dep)
+		lib.Version = NewVersion(dep.VersionSpec.Major, dep.VersionSpec.Minor, dep.VersionSpec.Subminor)
+		libs = append(libs, lib)
+	}
+	return libs
+}
+
+type libraryByImport []*Library
+
+func (self libraryByImport) Len() int           { return len(self) }
+func (self libraryByImport) Swap(i, j int)      { self[i], self[j] = self[j], self[i] }
+func (self libraryByImport) Less(i, j int) bool { return self[i].Import < self[j].Import }
+
+// Writes 'libs' to the lockfile at 'filename', sorted by import.  The file is
+// written next to its destination and renamed into place, so a failure never
+// leaves a partial lockfile behind.
+func WriteLockfile(filename string, libs []*Library) error {
+	sorted := make([]*Library, len(libs))
+	copy(sorted, libs)
+	sort.Sort(libraryByImport(sorted))
+
+	entries := []*toml.TomlTree{}
+	for _, lib := range sorted {
+		entries = append(entries, lib.ToTomlTree())
+	}
+	data := marshalToml(toml.TreeFromMap(map[string]interface{}{
+		"metadata": toml.TreeFromMap(map[string]interface{}{
+			"lock_version": int64(LockVersion),
+		}),
+		"dependencies": entries,
+	}))
+
+	file, err := ioutil.TempFile(path.Dir(filename), "."+path.Base(filename)+".")
+	if err != nil {
+		return err
+	}
+	tempName := file.Name()
+	if _, err = file.Write(data); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tempName, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tempName, filename)
+	}
+	if err != nil {
+		os.Remove(tempName)
+		return fmt.Errorf("Cannot write lock file '%s': %v", filename, err)
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestWriteLockfile(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "grapnel-lockfile")
+	if err != nil {
+		t.Errorf("Error creating temp dir: %v", err)
+		return
+	}
+	defer os.RemoveAll(tempDir)
+
+	libs := []*Library{}
+	for _, name := range []string{"foo/gorf", "foo/bar", "foo/baz"} {
+		dep, _ := NewDependency(name, "http://github.com/"+name, "")
+		dep.Asset = "odd \"asset\"\\name"
+		lib := NewLibrary(dep)
+		lib.Version = NewVersion(1, 0, 0)
+		libs = append(libs, lib)
+	}
+	lockFile := path.Join(tempDir, "grapnel-lock.toml")
+	if err := WriteLockfile(lockFile, libs); err != nil {
+		t.Errorf("Error writing lockfile: %v", err)
+		return
+	}
+	first, _ := ioutil.ReadFile(lockFile)
+
+	// entries are sorted by import, and strings survive the trip
+	deps, err := LoadLockfile(lockFile)
+	if err != nil {
+		t.Errorf("Error reading lockfile: %v\n%s", err, first)
+		return
+	}
+	imports := []string{}
+	for _, dep := range deps.Dependencies {
+		imports = append(imports, dep.Import)
+		if dep.Asset != libs[0].Asset {
+			t.Errorf("Bad asset for %v: %v", dep.Import, dep.Asset)
+		}
+	}
+	if !reflect.DeepEqual(imports, []string{"foo/bar", "foo/baz", "foo/gorf"}) {
+		t.Errorf("Lockfile is not sorted by import: %v", imports)
+	}
+
+	// the same libraries in any order write the same file
+	libs[0], libs[2] = libs[2], libs[0]
+	if err := WriteLockfile(lockFile, libs); err != nil {
+		t.Errorf("Error writing lockfile: %v", err)
+	}
+	if second, _ := ioutil.ReadFile(lockFile); !bytes.Equal(first, second) {
+		t.Errorf("Lockfile changed:\n%s\n%s", first, second)
+	}
+	if files, _ := ioutil.ReadDir(tempDir); len(files) != 1 {
+		t.Errorf("Expected only the lockfile in %v; got %v files", tempDir, len(files))
+	}
+
+	// negative test
+	if err := WriteLockfile(path.Join(tempDir, "missing", "grapnel-lock.toml"), libs); err == nil {
+		t.Errorf("Wrote a lockfile into a missing directory")
+	}
+}
+
+func TestLoadLockfile(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "grapnel-lockfile")
+	if err != nil {
+		t.Errorf("Error creating temp dir: %v", err)
+		return
+	}
+	defer os.RemoveAll(tempDir)
+	lockFile := path.Join(tempDir, "grapnel-lock.toml")
+
+	if lockfile, err := LoadLockfile(lockFile); lockfile != nil || err != nil {
+		t.Errorf("Missing lockfile should load as nil: %v %v", lockfile, err)
+	}
+
+	entry := "\n[[dependencies]]\n" +
+		"version = \"1.2.3\"\n" +
+		"import = \"foo/bar\"\n" +
+		"url = \"http://github.com/foo/bar\"\n" +
+		"requested_import = \"example.com/bar\"\n" +
+		"requested_version = \"1.2\"\n" +
+		"required_by = [\"foo/baz\"]\n"
+
+	for _, test := range []struct {
+		header           string
+		version          int
+		requestedImport  string
+		requestedVersion string
+	}{
+		{"", 1, "", ""},
+		{"[metadata]\nlock_version = 1\n", 1, "", ""},
+		{"[metadata]\nlock_version = 2\n", 2, "example.com/bar", "1.2"},
+	} {
+		ioutil.WriteFile(lockFile, []byte(test.header+entry), 0644)
+		lockfile, err := LoadLockfile(lockFile)
+		if err != nil {
+			t.Errorf("Error loading lockfile: %v\n%s", err, test.header)
+			continue
+		}
+		if lockfile.Version != test.version {
+			t.Errorf("Expected lock_version %v; got %v", test.version, lockfile.Version)
+		}
+		dep := lockfile.Dependencies[0]
+		if dep.RequestedImport != test.requestedImport || dep.RequestedVersion != test.requestedVersion {
+			t.Errorf("Bad requested import and version for version %v: '%v' '%v'",
+				test.version, dep.RequestedImport, dep.RequestedVersion)
+		}
+		if test.version < LockVersion && dep.RequiredBy != nil {
+			t.Errorf("Graph survived migration from version %v: %v", test.version, dep.RequiredBy)
+		}
+	}
+
+	// negative tests
+	for _, header := range []string{
+		"[metadata]\nlock_version = 3\n",
+		"[metadata]\nlock_version = 0\n",
+		"[metadata]\nlock_version = \"2\"\n",
+	} {
+		ioutil.WriteFile(lockFile, []byte(header+entry), 0644)
+		if _, err := LoadLockfile(lockFile); err == nil {
+			t.Errorf("Bad lockfile loaded okay: %v", header)
+		}
+	}
+	ioutil.WriteFile(lockFile, []byte("dependencies = 1\n"), 0644)
+	if _, err := LoadLockfile(lockFile); err == nil {
+		t.Errorf("Bad lockfile loaded okay: dependencies = 1")
+	}
+}
+
+func TestMigrateLockfile(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "grapnel-lockfile")
+	if err != nil {
+		t.Errorf("Error creating temp dir: %v", err)
+		return
+	}
+	defer os.RemoveAll(tempDir)
+	lockFile := path.Join(tempDir, "grapnel-lock.toml")
+
+	ioutil.WriteFile(lockFile, []byte(
+		"\n[[dependencies]]\n"+
+			"version = \"1.2.3\"\n"+
+			"type = \"git\"\n"+
+			"import = \"foo/gorf\"\n"+
+			"tag = \"v1.2.3\"\n"+
+			"\n[[dependencies]]\n"+
+			"# Unversioned\n"+
+			"type = \"git\"\n"+
+			"import = \"foo/bar\"\n"+
+			"tag = \"0123456789abcdef\"\n"), 0644)
+	old, err := LoadLockfile(lockFile)
+	if err != nil {
+		t.Errorf("Error loading lockfile: %v", err)
+		return
+	}
+	if err := WriteLockfile(lockFile, old.Libraries()); err != nil {
+		t.Errorf("Error writing lockfile: %v", err)
+		return
+	}
+	migrated, err := LoadLockfile(lockFile)
+	if err != nil {
+		t.Errorf("Error loading migrated lockfile: %v", err)
+		return
+	}
+	if migrated.Version != LockVersion {
+		t.Errorf("Migrated lockfile is version %v", migrated.Version)
+	}
+
+	// the migrated lockfile holds the same entries, sorted by import
+	if len(migrated.Dependencies) != 2 {
+		t.Errorf("Expected 2 dependencies; got %v", len(migrated.Dependencies))
+		return
+	}
+	for ii, expected := range []*Dependency{old.Dependencies[1], old.Dependencies[0]} {
+		result := migrated.Dependencies[ii]
+		if !reflect.DeepEqual(result.Flatten(), expected.Flatten()) ||
+			result.VersionSpec.String() != expected.VersionSpec.String() {
+			t.Errorf("Dependency %v changed in migration: %v %v", expected.Import,
+				result.Flatten(), result.VersionSpec)
+		}
+	}
+}
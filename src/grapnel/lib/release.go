@@ -0,0 +1,345 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	log "grapnel/log"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Release API flavors
+const (
+	ReleaseApiGitHub = "github"
+	ReleaseApiGitLab = "gitlab"
+)
+
+// Maps a repository host onto the REST API that lists its releases
+type ReleaseApi struct {
+	Host string // repository host, as it appears in dependency urls
+	Api  string // 'github' or 'gitlab'
+	Url  string // API base url
+}
+
+// APIs used when nothing in the configuration matches the host
+var DefaultReleaseApis = []*ReleaseApi{
+	{Host: "github.com", Api: ReleaseApiGitHub, Url: "https://api.github.com"},
+	{Host: "gitlab.com", Api: ReleaseApiGitLab, Url: "https://gitlab.com/api/v4"},
+}
+
+// Fetches source tarballs, or named assets, attached to project releases
+type ReleaseSCM struct {
+	Apis        []*ReleaseApi
+	Credentials Credentials // per-host HTTP credentials
+	Cache       *Cache      // downloads kept between runs; optional
+}
+
+// A release, boiled down to what is common to each API flavor
+type releaseInfo struct {
+	Tag     string
+	Tarball string
+	Assets  map[string]string
+}
+
+// subset of a GitHub release
+type gitHubRelease struct {
+	TagName    string `json:"tag_name"`
+	Draft      bool   `json:"draft"`
+	Prerelease bool   `json:"prerelease"`
+	TarballUrl string `json:"tarball_url"`
+	Assets     []struct {
+		Name               string `json:"name"`
+		BrowserDownloadUrl string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+// subset of a GitLab release
+type gitLabRelease struct {
+	TagName         string `json:"tag_name"`
+	UpcomingRelease bool   `json:"upcoming_release"`
+	Assets          struct {
+		Sources []struct {
+			Format string `json:"format"`
+			Url    string `json:"url"`
+		} `json:"sources"`
+		Links []struct {
+			Name string `json:"name"`
+			Url  string `json:"url"`
+		} `json:"links"`
+	} `json:"assets"`
+}
+
+// Finds the API for 'host', preferring the configured ones over the defaults.
+func (self *ReleaseSCM) findApi(host string) *ReleaseApi {
+	for _, apis := range [][]*ReleaseApi{self.Apis, DefaultReleaseApis} {
+		for _, api := range apis {
+			if api.Host == host {
+				return api
+			}
+		}
+	}
+	return nil
+}
+
+// Finds the url of the page after 'current' from the response headers: the
+// 'Link' header on GitHub, or 'X-Next-Page' on GitLab.  Returns "" for the
+// last page.
+func nextPageUrl(current string, header http.Header) (string, error) {
+	currentUrl, err := url.Parse(current)
+	if err != nil {
+		return "", err
+	}
+	for _, link := range strings.Split(header.Get("Link"), ",") {
+		parts := strings.Split(link, ";")
+		target := strings.TrimSpace(parts[0])
+		if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+			continue
+		}
+		for _, param := range parts[1:] {
+			if strings.Replace(strings.TrimSpace(param), " ", "", -1) == `rel="next"` {
+				nextUrl, err := currentUrl.Parse(target[1 : len(target)-1])
+				if err != nil {
+					return "", fmt.Errorf("Bad 'Link' header from '%s': %v", current, err)
+				}
+				return nextUrl.String(), nil
+			}
+		}
+	}
+	if page := strings.TrimSpace(header.Get("X-Next-Page")); page != "" {
+		query := currentUrl.Query()
+		query.Set("page", page)
+		currentUrl.RawQuery = query.Encode()
+		return currentUrl.String(), nil
+	}
+	return "", nil
+}
+
+// Fetches the release list at 'target', following it across pages.  The pages
+// are cached together as one list, so that it is complete offline.
+func getReleaseJson(target string, value interface{}, creds Credentials, cache *Cache) error {
+	data, err := cache.Metadata(target, func() ([]byte, error) {
+		items := []json.RawMessage{}
+		seen := map[string]bool{}
+		for next := target; next != "" && !seen[next]; {
+			seen[next] = true
+			log.Debug("GET %s", next)
+			response, err := httpGet(next, creds)
+			if err != nil {
+				return nil, err
+			}
+			data, err := ioutil.ReadAll(response.Body)
+			response.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+			page := []json.RawMessage{}
+			if err := json.Unmarshal(data, &page); err != nil {
+				return nil, fmt.Errorf("Bad release list from '%s': %v", next, err)
+			}
+			items = append(items, page...)
+			if next, err = nextPageUrl(next, response.Header); err != nil {
+				return nil, err
+			}
+		}
+		return json.Marshal(items)
+	})
+	if err != nil {
+		return fmt.Errorf("Cannot list releases: %v", err)
+	}
+	if err := json.Unmarshal(data, value); err != nil {
+		return fmt.Errorf("Bad release list from '%s': %v", target, err)
+	}
+	return nil
+}
+
+// Lists the published releases for 'project', newest first.  Drafts and
+// pre-releases are left out.
+func listReleases(api *ReleaseApi, project string, creds Credentials, cache *Cache) ([]*releaseInfo, error) {
+	baseUrl := strings.TrimSuffix(api.Url, "/")
+	results := []*releaseInfo{}
+	switch api.Api {
+	case ReleaseApiGitHub:
+		releases := []*gitHubRelease{}
+		target := baseUrl + "/repos/" + project + "/releases?per_page=100"
+		if err := getReleaseJson(target, &releases, creds, cache); err != nil {
+			return nil, err
+		}
+		for _, release := range releases {
+			if release.Draft || release.Prerelease {
+				continue
+			}
+			info := &releaseInfo{
+				Tag:     release.TagName,
+				Tarball: release.TarballUrl,
+				Assets:  map[string]string{},
+			}
+			for _, asset := range release.Assets {
+				info.Assets[asset.Name] = asset.BrowserDownloadUrl
+			}
+			results = append(results, info)
+		}
+	case ReleaseApiGitLab:
+		releases := []*gitLabRelease{}
+		target := baseUrl + "/projects/" + url.QueryEscape(project) + "/releases?per_page=100"
+		if err := getReleaseJson(target, &releases, creds, cache); err != nil {
+			return nil, err
+		}
+		for _, release := range releases {
+			if release.UpcomingRelease {
+				continue
+			}
+			info := &releaseInfo{
+				Tag:    release.TagName,
+				Assets: map[string]string{},
+			}
+			for _, source := range release.Assets.Sources {
+				if source.Format == "tar.gz" {
+					info.Tarball = source.Url
+				}
+			}
+			for _, link := range release.Assets.Links {
+				info.Assets[link.Name] = link.Url
+			}
+			results = append(results, info)
+		}
+	default:
+		return nil, fmt.Errorf("Unknown release API: '%s'", api.Api)
+	}
+	return results, nil
+}
+
+// Picks the url of the asset that matches 'pattern'.  The match must be
+// unambiguous.
+func findReleaseAsset(release *releaseInfo, pattern string) (string, error) {
+	found := ""
+	for name, assetUrl := range release.Assets {
+		if matched, err := path.Match(pattern, name); err != nil {
+			return "", fmt.Errorf("Bad asset pattern '%s': %v", pattern, err)
+		} else if !matched {
+			continue
+		}
+		if found != "" {
+			return "", fmt.Errorf("Asset pattern '%s' matches more than one asset in release '%s'",
+				pattern, release.Tag)
+		}
+		found = assetUrl
+	}
+	if found == "" {
+		return "", fmt.Errorf("No asset matching '%s' in release '%s'", pattern, release.Tag)
+	}
+	return found, nil
+}
+
+func (self *ReleaseSCM) Resolve(dep *Dependency) (*Library, error) {
+	lib := NewLibrary(dep)
+
+	if lib.Url == nil {
+		return nil, fmt.Errorf("A release dependency requires a url: '%s'", lib.Import)
+	}
+	api := self.findApi(lib.Url.Host)
+	if api == nil {
+		return nil, fmt.Errorf("No release API configured for host: '%s'", lib.Url.Host)
+	}
+	project := strings.TrimSuffix(strings.Trim(lib.Url.Path, "/"), ".git")
+
+	log.Info("Fetching Release Dependency: '%s'", lib.Import)
+
+	releases, err := listReleases(api, project, self.Credentials, self.Cache)
+	if err != nil {
+		return nil, err
+	}
+
+	// figure out which release to fetch; a tag pins an exact release
+	var release *releaseInfo
+	if lib.Tag != "" {
+		for _, candidate := range releases {
+			if candidate.Tag == lib.Tag {
+				release = candidate
+				break
+			}
+		}
+		if release == nil {
+			return nil, fmt.Errorf("Cannot find release '%s' for dependency: '%s'", lib.Tag, lib.Import)
+		}
+		// a pinned tag still has to satisfy the version spec
+		ver, err := ParseVersion(lib.Tag)
+		if !lib.VersionSpec.IsUnversioned() && (err != nil || !lib.VersionSpec.IsSatisfiedBy(ver)) {
+			return nil, fmt.Errorf("Tag '%s' does not satisfy version specification: %v.", lib.Tag, lib.VersionSpec)
+		}
+		if err == nil {
+			lib.Version = ver
+		} else {
+			lib.Version = NewVersion(-1, -1, -1)
+		}
+	} else {
+		tags := []string{}
+		byTag := map[string]*releaseInfo{}
+		for _, candidate := range releases {
+			tags = append(tags, candidate.Tag)
+			byTag[candidate.Tag] = candidate
+		}
+		if tag, version, err := SelectTag(lib.VersionSpec, lib.Strategy, tags); err == nil {
+			release = byTag[tag]
+			lib.Version = version
+		} else if lib.VersionSpec.IsUnversioned() && len(releases) > 0 {
+			release = releases[0]
+			lib.Version = NewVersion(-1, -1, -1)
+		} else {
+			return nil, err
+		}
+		lib.Tag = release.Tag
+	}
+
+	// source tarballs always wrap the tree in a single directory
+	downloadUrl := release.Tarball
+	strip := lib.StripComponents + 1
+	if lib.Asset != "" {
+		if downloadUrl, err = findReleaseAsset(release, lib.Asset); err != nil {
+			return nil, err
+		}
+		strip = lib.StripComponents
+	} else if downloadUrl == "" {
+		return nil, fmt.Errorf("Release '%s' has no source tarball", release.Tag)
+	}
+
+	log.Info("Fetching remote data for %s", lib.Import)
+	if err := fetchArchive(lib, downloadUrl, strip, self.Credentials, self.Cache); err != nil {
+		return nil, err
+	}
+
+	if lib.Version.Major == -1 {
+		log.Warn("Resolved: %v (unversioned)", lib.Import)
+	} else {
+		log.Info("Resolved: %s %v", lib.Import, lib.Version)
+	}
+	return lib, nil
+}
+
+func (self *ReleaseSCM) ToDSD(lib *Library) string {
+	return archiveDSD(lib)
+}
@@ -0,0 +1,169 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Serves a GitHub and a GitLab flavored release API for 'foo/bar'.
+func startTestReleaseServer() *httptest.Server {
+	source := gzipBytes(buildTestTar(testArchiveEntries))
+	asset := gzipBytes(buildTestTar([]testArchiveEntry{
+		{"dist/", ""},
+		{"dist/README", "vetted"},
+	}))
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		base := server.URL
+		switch r.URL.EscapedPath() {
+		case "/api/v3/repos/foo/bar/releases":
+			// the oldest release is on a second page
+			if r.URL.Query().Get("page") == "2" {
+				w.Write([]byte(strings.Replace(`[
+					{"tag_name": "v1.0.0", "tarball_url": "BASE/src/v1.0.0"}
+				]`, "BASE", base, -1)))
+				return
+			}
+			next := base + r.URL.Path + "?per_page=100&page=2"
+			w.Header().Set("Link", "<"+next+`>; rel="next", <`+next+`>; rel="last"`)
+			w.Write([]byte(strings.Replace(`[
+				{"tag_name": "v2.1.0", "draft": true, "tarball_url": "BASE/src/v2.1.0"},
+				{"tag_name": "v2.0.0-rc1", "prerelease": true, "tarball_url": "BASE/src/v2.0.0-rc1"},
+				{"tag_name": "v1.1.0", "tarball_url": "BASE/src/v1.1.0", "assets": [
+					{"name": "bar-1.1.0.tar.gz", "browser_download_url": "BASE/asset/bar-1.1.0.tar.gz"},
+					{"name": "bar-1.1.0.tar.gz.asc", "browser_download_url": "BASE/asset/sig"}]}
+			]`, "BASE", base, -1)))
+		case "/api/v4/projects/foo%2Fbar/releases":
+			if r.URL.Query().Get("page") == "2" {
+				w.Write([]byte(strings.Replace(`[
+					{"tag_name": "v0.9.0", "assets": {
+						"sources": [{"format": "tar.gz", "url": "BASE/src/v0.9.0"}]}}
+				]`, "BASE", base, -1)))
+				return
+			}
+			w.Header().Set("X-Next-Page", "2")
+			w.Write([]byte(strings.Replace(`[
+				{"tag_name": "v3.0.0", "upcoming_release": true},
+				{"tag_name": "v1.2.0", "assets": {
+					"sources": [{"format": "zip", "url": "BASE/missing.zip"},
+						{"format": "tar.gz", "url": "BASE/src/v1.2.0"}],
+					"links": [{"name": "bar.tar.gz", "url": "BASE/asset/bar.tar.gz"}]}}
+			]`, "BASE", base, -1)))
+		default:
+			if strings.HasPrefix(r.URL.Path, "/src/") {
+				w.Write(source)
+			} else if strings.HasPrefix(r.URL.Path, "/asset/") {
+				w.Write(asset)
+			} else {
+				http.NotFound(w, r)
+			}
+		}
+	}))
+	return server
+}
+
+func TestReleaseSource(t *testing.T) {
+	server := startTestReleaseServer()
+	defer server.Close()
+	libsrc := &ReleaseSCM{Apis: []*ReleaseApi{
+		{Host: "github.example.com", Api: ReleaseApiGitHub, Url: server.URL + "/api/v3"},
+		{Host: "gitlab.example.com", Api: ReleaseApiGitLab, Url: server.URL + "/api/v4/"},
+	}}
+
+	for _, test := range []struct {
+		Url      string
+		Spec     string
+		Tag      string
+		Asset    string
+		Strategy int
+		Expected string
+		Content  string
+	}{
+		{"https://github.example.com/foo/bar", "", "", "", StrategyNewest, "v1.1.0", "readme"},
+		{"https://github.example.com/foo/bar.git", "1.0", "", "", StrategyNewest, "v1.0.0", "readme"},
+		{"https://github.example.com/foo/bar", ">=1", "", "", StrategyMinimal, "v1.0.0", "readme"},
+		{"https://github.example.com/foo/bar", "", "v1.1.0", "*.tar.gz", StrategyNewest, "v1.1.0", "vetted"},
+		{"https://github.example.com/foo/bar", "1.*", "v1.0.0", "", StrategyNewest, "v1.0.0", "readme"},
+		{"https://gitlab.example.com/foo/bar", "1", "", "", StrategyNewest, "v1.2.0", "readme"},
+		{"https://gitlab.example.com/foo/bar", "0.9", "", "", StrategyNewest, "v0.9.0", "readme"},
+		{"https://gitlab.example.com/foo/bar", "", "", "bar.tar.gz", StrategyNewest, "v1.2.0", "vetted"},
+	} {
+		dep, err := NewDependency("example.com/foo/bar", test.Url, test.Spec)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		dep.Tag = test.Tag
+		dep.Asset = test.Asset
+		dep.Strategy = test.Strategy
+		if test.Asset != "" {
+			dep.StripComponents = 1
+		}
+		lib, err := libsrc.Resolve(dep)
+		if err != nil {
+			t.Errorf("Error resolving '%v' %v: %v", test.Url, test.Spec, err)
+			continue
+		}
+		defer os.RemoveAll(lib.TempDir)
+		if lib.Tag != test.Expected {
+			t.Errorf("'%v' %v resolved to '%v', expected '%v'", test.Url, test.Spec, lib.Tag, test.Expected)
+		}
+		if lib.Sha256 == "" {
+			t.Errorf("No sha256 recorded for '%v'", test.Url)
+		}
+		if data, err := ioutil.ReadFile(filepath.Join(lib.TempDir, "README")); err != nil {
+			t.Errorf("Missing 'README' from release: %v", err)
+		} else if string(data) != test.Content {
+			t.Errorf("Bad README content: '%v'. Expected: '%v'", string(data), test.Content)
+		}
+	}
+
+	// negative tests
+	for _, test := range []struct {
+		Url   string
+		Spec  string
+		Tag   string
+		Asset string
+	}{
+		{"https://github.example.com/foo/bar", "3", "", ""},
+		{"https://github.example.com/foo/bar", "", "v2.1.0", ""},
+		{"https://github.example.com/foo/bar", "2.*", "v1.1.0", ""},
+		{"https://github.example.com/foo/bar", "", "", "bar-1.1.0.*"},
+		{"https://github.example.com/foo/bar", "", "", "*.zip"},
+		{"https://github.example.com/foo/baz", "", "", ""},
+		{"https://unknown.example.com/foo/bar", "", "", ""},
+	} {
+		dep, _ := NewDependency("example.com/foo/bar", test.Url, test.Spec)
+		dep.Tag = test.Tag
+		dep.Asset = test.Asset
+		if _, err := libsrc.Resolve(dep); err == nil {
+			t.Errorf("Resolved '%v' %v (tag '%v', asset '%v') okay", test.Url, test.Spec, test.Tag, test.Asset)
+		}
+	}
+}
@@ -23,8 +23,12 @@ THE SOFTWARE.
 */
 
 import (
+	"bytes"
 	"fmt"
 	log "grapnel/log"
+	"io/ioutil"
+	"os"
+	"strings"
 )
 
 type LibSource interface {
@@ -37,6 +41,23 @@ type LibSourceMap map[string]LibSource
 type Resolver struct {
 	LibSources   LibSourceMap
 	RewriteRules RewriteRuleArray
+	Strategy     int    // version selection strategy handed to each dependency
+	Store        *Store // installs hardlink to this store when set
+	LockedGraph  bool   // the dependencies are a complete graph; don't look for more
+
+	// specs learned from conflicts in earlier passes over the graph
+	constraints map[string]*VersionSpec
+}
+
+// Raised when a library was resolved at a version that a later dependency
+// rules out, where some other version could satisfy both.
+type versionConflict struct {
+	Import string       // import of the library to resolve again
+	Spec   *VersionSpec // what its version must satisfy
+}
+
+func (self *versionConflict) Error() string {
+	return fmt.Sprintf("'%v' must be resolved again as '%v'", self.Import, self.Spec)
 }
 
 func NewResolver() *Resolver {
@@ -50,13 +71,47 @@ func (self *Resolver) AddRewriteRules(rules RewriteRuleArray) {
 	self.RewriteRules = append(self.RewriteRules, rules...)
 }
 
+// Reports if 'deps' is a complete graph, as written by a lockfile: every
+// entry records what was requested, and every 'required_by' edge names
+// another entry.  Such a graph can be resolved without scanning sources.
+func IsLockedGraph(deps []*Dependency) bool {
+	imports := map[string]bool{}
+	for _, dep := range deps {
+		if dep.RequestedImport == "" {
+			return false
+		}
+		imports[dep.Import] = true
+	}
+	for _, dep := range deps {
+		for _, name := range dep.RequiredBy {
+			if !imports[name] {
+				return false
+			}
+		}
+	}
+	return len(deps) > 0
+}
+
 // resolve a single dependency
 func (self *Resolver) Resolve(dep *Dependency) (*Library, error) {
+	// keep the import that was asked for, before rewrite rules change it
+	if dep.RequestedImport == "" {
+		dep.RequestedImport = dep.Import
+	}
+
 	// apply rewrite rules
-	// TODO: consider preserving original dependency
 	if err := self.RewriteRules.Apply(dep); err != nil {
 		return nil, err
 	}
+	dep.Strategy = self.Strategy
+
+	// rule out versions that conflicted in an earlier pass
+	if spec, ok := self.constraints[dep.Import]; ok {
+		if spec = dep.VersionSpec.Intersect(spec); spec == nil {
+			return nil, fmt.Errorf("Cannot reconcile '%v'", dep.Import)
+		}
+		dep.VersionSpec = spec
+	}
 
 	// match by registered type - rewrite rules should have set 'type' by now
 	if source, ok := self.LibSources[dep.Type]; ok {
@@ -69,12 +124,23 @@ func (self *Resolver) Resolve(dep *Dependency) (*Library, error) {
 			return nil, err
 		}
 
+		// a locked graph already lists everything this library needs
+		if self.LockedGraph {
+			return lib, nil
+		}
+
 		// follow up with lib specific touches
 		err = lib.AddDependencies()
 		if err != nil {
 			return nil, err
 		}
 
+		// every dependency found here is required by this library, whatever
+		// the graph it came from said
+		for _, child := range lib.Dependencies {
+			child.RequiredBy = nil
+			child.AddRequiredBy(lib.Import)
+		}
 		return lib, nil
 	}
 
@@ -93,6 +159,8 @@ func (self *Resolver) DeduplicateDeps(deps []*Dependency) ([]*Dependency, error)
 				if next, err := src.Reconcile(dest); err != nil {
 					return nil, err
 				} else {
+					next.AddRequiredBy(src.RequiredBy...)
+					next.AddRequiredBy(dest.RequiredBy...)
 					deps[jj] = next
 					break
 				}
@@ -112,8 +180,13 @@ func (self *Resolver) LibResolveDeps(libs map[string]*Library, deps []*Dependenc
 	for _, dep := range deps {
 		if lib, ok := libs[dep.Import]; ok {
 			if !dep.VersionSpec.IsSatisfiedBy(lib.Version) {
+				// another version may yet satisfy both
+				if spec := lib.VersionSpec.Intersect(dep.VersionSpec); spec != nil {
+					return nil, &versionConflict{Import: lib.Import, Spec: spec}
+				}
 				return nil, fmt.Errorf("Cannot reconcile '%v'", dep.Import)
 			}
+			lib.AddRequiredBy(dep.RequiredBy...)
 		} else {
 			tempQueue = append(tempQueue, dep)
 		}
@@ -123,22 +196,52 @@ func (self *Resolver) LibResolveDeps(libs map[string]*Library, deps []*Dependenc
 
 // resolve all dependencies against configuration
 func (self *Resolver) ResolveDependencies(deps []*Dependency) ([]*Library, error) {
+	self.constraints = map[string]*VersionSpec{}
+	for {
+		libs, err := self.resolveGraph(deps)
+		conflict, ok := err.(*versionConflict)
+		if !ok {
+			return libs, err
+		}
+
+		// every constraint only ever narrows the versions a library may be,
+		// so starting over with it eventually settles, or fails to resolve
+		log.Info("Resolving again: %v", conflict)
+		self.constraints[conflict.Import] = conflict.Spec
+	}
+}
+
+// Makes a single pass over the dependency graph.  The libraries resolved are
+// destroyed if the pass fails.
+func (self *Resolver) resolveGraph(deps []*Dependency) ([]*Library, error) {
 	masterLibs := []*Library{}
 	resolved := map[string]*Library{}
 	results := make(chan *Library)
 	errors := make(chan error)
-	workQueue := deps
+
+	// resolve copies, leaving 'deps' as they were for another pass
+	workQueue := []*Dependency{}
+	for _, dep := range deps {
+		copied := *dep
+		workQueue = append(workQueue, &copied)
+	}
+	fail := func(err error) ([]*Library, error) {
+		for _, lib := range masterLibs {
+			lib.Destroy()
+		}
+		return nil, err
+	}
 
 	for len(workQueue) > 0 {
 		// de-duplicate the queue
 		var err error
 		if workQueue, err = self.DeduplicateDeps(workQueue); err != nil {
-			return nil, err
+			return fail(err)
 		}
 
 		// look for already resolved deps that may match
 		if workQueue, err = self.LibResolveDeps(resolved, workQueue); err != nil {
-			return nil, err
+			return fail(err)
 		}
 
 		// spawn goroutines for each dependency to be resolved
@@ -174,23 +277,45 @@ func (self *Resolver) ResolveDependencies(deps []*Dependency) ([]*Library, error
 			}
 		}
 		if failed {
-			return nil, fmt.Errorf("One or more errors while resolving dependencies.")
+			return fail(fmt.Errorf("One or more errors while resolving dependencies."))
 		}
 		workQueue = tempQueue
 	}
 	return masterLibs, nil
 }
 
+// Writes a standalone shell script that reproduces 'libs' at their pinned
+// versions.  The script takes the target path as its only argument.
 func (self *Resolver) ToDsd(filename string, libs []*Library) error {
-	fmt.Printf("#!/bin/bash\n")
-	fmt.Printf("# Grapnel Dead-simple Downloader\n\n")
-	// TODO: call toDSD on all libs via assigned resolver
-	return nil
+	writer := &bytes.Buffer{}
+	fmt.Fprint(writer, dsdHeader)
+	for _, lib := range libs {
+		source, ok := self.LibSources[lib.Type]
+		if !ok {
+			return fmt.Errorf("Cannot identify resolver for library: '%v'", lib.Import)
+		}
+		fetch := source.ToDSD(lib)
+		if fetch == "" {
+			return fmt.Errorf("Cannot write a dsd entry for library '%v' of type '%v'", lib.Import, lib.Type)
+		}
+		lib.ToDsd(writer, fetch)
+	}
+	fmt.Fprintf(writer, "\necho \"grapnel-dsd: installed %d libraries to $target\"\n", len(libs))
+	if err := ioutil.WriteFile(filename, writer.Bytes(), 0755); err != nil {
+		return err
+	}
+	return os.Chmod(filename, 0755)
 }
 
 func (self *Resolver) InstallLibraries(installRoot string, libs []*Library) error {
 	for _, lib := range libs {
-		if err := lib.Install(installRoot); err != nil {
+		nested := []string{}
+		for _, other := range libs {
+			if strings.HasPrefix(other.Import, lib.Import+"/") {
+				nested = append(nested, other.Import)
+			}
+		}
+		if err := lib.Install(installRoot, self.Store, nested); err != nil {
 			return fmt.Errorf("While installing %v: %v", lib.Import, err)
 		}
 	}
@@ -23,8 +23,11 @@ THE SOFTWARE.
 */
 
 import (
+	"fmt"
 	log "grapnel/log"
 	url "grapnel/url"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -210,3 +213,204 @@ func TestResolveDependencies(t *testing.T) {
 			len(testDeps), len(libs))
 	}
 }
+
+// resolves libraries that depend on the imports listed for them
+type testGraphSCM map[string][]string
+
+func (self testGraphSCM) Resolve(dep *Dependency) (*Library, error) {
+	lib := NewLibrary(dep)
+	lib.Version = NewVersion(1, 0, 0)
+	for _, name := range self[dep.Import] {
+		lib.Dependencies = append(lib.Dependencies, &Dependency{
+			Import:      name,
+			VersionSpec: NewVersionSpec(OpEq, 1, 0, -1),
+			Type:        "test",
+		})
+	}
+	return lib, nil
+}
+
+func (self testGraphSCM) ToDSD(*Library) string {
+	return ""
+}
+
+func TestRequiredBy(t *testing.T) {
+	log.SetGlobalLogLevel(log.DEBUG)
+
+	graph := testGraphSCM{
+		"app1": []string{"shared", "leaf"},
+		"app2": []string{"shared"},
+		"leaf": []string{"shared"},
+	}
+	resolver := &Resolver{
+		LibSources: map[string]LibSource{
+			"test": graph,
+		},
+	}
+
+	testDeps := []*Dependency{}
+	for _, name := range []string{"app1", "app2"} {
+		testDeps = append(testDeps, &Dependency{
+			Import:      name,
+			VersionSpec: NewVersionSpec(OpEq, 1, 0, -1),
+			Type:        "test",
+		})
+	}
+	libs, err := resolver.ResolveDependencies(testDeps)
+	if err != nil {
+		t.Errorf("Error resolving dependencies: %v", err)
+		return
+	}
+
+	expected := map[string][]string{
+		"app1":   nil,
+		"app2":   nil,
+		"leaf":   []string{"app1"},
+		"shared": []string{"app1", "app2", "leaf"},
+	}
+	if len(libs) != len(expected) {
+		t.Errorf("Expected %v libraries, got %v instead", len(expected), len(libs))
+	}
+	deps := []*Dependency{}
+	for _, lib := range libs {
+		if !reflect.DeepEqual(lib.RequiredBy, expected[lib.Import]) {
+			t.Errorf("Library %v is required by %v; expected %v",
+				lib.Import, lib.RequiredBy, expected[lib.Import])
+		}
+		if lib.RequestedImport != lib.Import {
+			t.Errorf("Library %v was requested as %v", lib.Import, lib.RequestedImport)
+		}
+		dep := lib.Dependency
+		deps = append(deps, &dep)
+	}
+
+	// the libraries form a complete graph, which resolves without a scan
+	if !IsLockedGraph(deps) {
+		t.Errorf("Resolved libraries should form a locked graph")
+	}
+	resolver.LockedGraph = true
+	if relocked, err := resolver.ResolveDependencies(deps); err != nil {
+		t.Errorf("Error resolving a locked graph: %v", err)
+	} else if len(relocked) != len(deps) {
+		t.Errorf("Locked graph resolved to %v libraries; expected %v", len(relocked), len(deps))
+	}
+
+	// an edge to a library outside the graph means it isn't complete
+	deps[0].AddRequiredBy("elsewhere")
+	if IsLockedGraph(deps) {
+		t.Errorf("A graph with a dangling edge should not be locked")
+	}
+	if IsLockedGraph(testDeps[:0]) {
+		t.Errorf("An empty graph should not be locked")
+	}
+}
+
+func TestAddRequiredBy(t *testing.T) {
+	dep := &Dependency{Import: "foo"}
+	dep.AddRequiredBy("gorf", "bar", "foo", "baz", "bar")
+	if !reflect.DeepEqual(dep.RequiredBy, []string{"bar", "baz", "gorf"}) {
+		t.Errorf("Bad required_by list: %v", dep.RequiredBy)
+	}
+
+	// a copy does not share later additions
+	other := *dep
+	other.AddRequiredBy("alpha")
+	if !reflect.DeepEqual(dep.RequiredBy, []string{"bar", "baz", "gorf"}) {
+		t.Errorf("Copy changed the original list: %v", dep.RequiredBy)
+	}
+}
+
+// resolves libraries at the version their spec selects; each version
+// depends on the specs listed for it
+type testVersionSCM map[string]map[string][]string
+
+func (self testVersionSCM) Resolve(dep *Dependency) (*Library, error) {
+	tags := []string{}
+	versions := []*Version{}
+	for tag := range self[dep.Import] {
+		ver, _ := ParseVersion(tag)
+		tags = append(tags, tag)
+		versions = append(versions, ver)
+	}
+	idx := dep.VersionSpec.Select(dep.Strategy, versions)
+	if idx < 0 {
+		return nil, fmt.Errorf("No version of '%v' matches '%v'", dep.Import, dep.VersionSpec)
+	}
+	lib := NewLibrary(dep)
+	lib.Version = versions[idx]
+	for _, entry := range self[dep.Import][tags[idx]] {
+		parts := strings.SplitN(entry, " ", 2)
+		child, _ := NewDependency(parts[0], "", parts[1])
+		child.Type = "test"
+		lib.Dependencies = append(lib.Dependencies, child)
+	}
+	return lib, nil
+}
+
+func (self testVersionSCM) ToDSD(*Library) string {
+	return ""
+}
+
+func TestResolveStrategies(t *testing.T) {
+	log.SetGlobalLogLevel(log.DEBUG)
+
+	source := testVersionSCM{
+		"a": {"1.0": {"c >=1.2"}, "1.1": {"c >=1.2"}},
+		"b": {"1.0": {"c >=1.0"}},
+		"c": {"1.0": {}, "1.2": {}, "1.3": {}},
+	}
+
+	for _, test := range []struct {
+		roots    []string
+		strategy int
+		expected map[string]string
+	}{
+		// the root asks for less of 'c' than 'a' does
+		{[]string{"a >=1.0", "c >=1.0"}, StrategyMinimal, map[string]string{"a": "1.0.*", "c": "1.2.*"}},
+		{[]string{"a >=1.0", "c >=1.0"}, StrategyNewest, map[string]string{"a": "1.1.*", "c": "1.3.*"}},
+		// a diamond: 'a' and 'b' both need 'c'
+		{[]string{"a >=1.0", "b >=1.0"}, StrategyMinimal, map[string]string{"a": "1.0.*", "b": "1.0.*", "c": "1.2.*"}},
+		{[]string{"a >=1.0", "b >=1.0"}, StrategyNewest, map[string]string{"a": "1.1.*", "b": "1.0.*", "c": "1.3.*"}},
+	} {
+		resolver := &Resolver{
+			LibSources: map[string]LibSource{"test": source},
+			Strategy:   test.strategy,
+		}
+		deps := []*Dependency{}
+		for _, root := range test.roots {
+			parts := strings.SplitN(root, " ", 2)
+			dep, _ := NewDependency(parts[0], "", parts[1])
+			dep.Type = "test"
+			deps = append(deps, dep)
+		}
+		libs, err := resolver.ResolveDependencies(deps)
+		if err != nil {
+			t.Errorf("Error resolving %v with strategy %v: %v", test.roots, test.strategy, err)
+			continue
+		}
+		result := map[string]string{}
+		for _, lib := range libs {
+			result[lib.Import] = lib.Version.String()
+		}
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("%v with strategy %v resolved to %v; expected %v",
+				test.roots, test.strategy, result, test.expected)
+		}
+	}
+
+	// negative test: no version of 'c' satisfies both
+	resolver := &Resolver{
+		LibSources: map[string]LibSource{"test": source},
+		Strategy:   StrategyMinimal,
+	}
+	deps := []*Dependency{}
+	for _, root := range []string{"a >=1.0", "c <1.2"} {
+		parts := strings.SplitN(root, " ", 2)
+		dep, _ := NewDependency(parts[0], "", parts[1])
+		dep.Type = "test"
+		deps = append(deps, dep)
+	}
+	if _, err := resolver.ResolveDependencies(deps); err == nil {
+		t.Errorf("Conflicting specs for 'c' resolved okay")
+	}
+}
@@ -27,6 +27,7 @@ import (
 	"math"
 	"regexp"
 	"strconv"
+	"strings"
 )
 
 type VersionSpec struct {
@@ -40,6 +41,7 @@ type VersionSpec struct {
 	maxMinor    int
 	minSubminor int
 	maxSubminor int
+	parts       []*VersionSpec // the specs this one is the intersection of
 }
 
 type Version struct {
@@ -57,6 +59,13 @@ const (
 )
 
 func (self *VersionSpec) String() string {
+	if len(self.parts) > 0 {
+		names := []string{}
+		for _, part := range self.parts {
+			names = append(names, part.String())
+		}
+		return strings.Join(names, ", ")
+	}
 	var op string
 	switch self.Oper {
 	case OpLt:
@@ -101,6 +110,20 @@ func (self *Version) String() string {
 	return fmt.Sprintf("%v.%v.%v", self.Major, minor, subminor)
 }
 
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
 func getMinMax(oper int, value int) (int, int) {
 	var min, max int
 	if value == -1 {
@@ -230,6 +253,36 @@ func (self *VersionSpec) Outranks(other *VersionSpec) bool {
 		self.minSubminor >= other.minSubminor && self.maxSubminor <= other.maxSubminor
 }
 
+// Returns a spec that is satisfied only by versions that satisfy both self
+// and 'other', or nil if no version can.
+func (self *VersionSpec) Intersect(other *VersionSpec) *VersionSpec {
+	if other.IsUnversioned() {
+		return self
+	} else if self.IsUnversioned() {
+		return other
+	}
+	result := *self
+	result.parts = []*VersionSpec{}
+	for _, spec := range []*VersionSpec{self, other} {
+		if len(spec.parts) > 0 {
+			result.parts = append(result.parts, spec.parts...)
+		} else {
+			result.parts = append(result.parts, spec)
+		}
+	}
+	result.minMajor = maxInt(self.minMajor, other.minMajor)
+	result.maxMajor = minInt(self.maxMajor, other.maxMajor)
+	result.minMinor = maxInt(self.minMinor, other.minMinor)
+	result.maxMinor = minInt(self.maxMinor, other.maxMinor)
+	result.minSubminor = maxInt(self.minSubminor, other.minSubminor)
+	result.maxSubminor = minInt(self.maxSubminor, other.maxSubminor)
+	if result.minMajor > result.maxMajor || result.minMinor > result.maxMinor ||
+		result.minSubminor > result.maxSubminor {
+		return nil
+	}
+	return &result
+}
+
 // Compares the range of possible valid versions in the spec to a specific version
 // Returns true if 'version' satisfies the specification
 func (self *VersionSpec) IsSatisfiedBy(version *Version) bool {
@@ -241,3 +294,75 @@ func (self *VersionSpec) IsSatisfiedBy(version *Version) bool {
 func (self *VersionSpec) IsUnversioned() bool {
 	return self.Major == -1
 }
+
+// Resolution strategies for picking a version out of a set of candidates
+const (
+	StrategyNewest = iota
+	StrategyMinimal
+)
+
+func ParseStrategy(src string) (int, error) {
+	switch src {
+	case "", "newest":
+		return StrategyNewest, nil
+	case "minimal":
+		return StrategyMinimal, nil
+	}
+	return StrategyNewest, fmt.Errorf("Unknown resolution strategy: '%s'", src)
+}
+
+// Returns -1, 0 or 1 if self is less than, equal to, or greater than 'other'.
+func (self *Version) Compare(other *Version) int {
+	for _, pair := range [][2]int{
+		{self.Major, other.Major},
+		{self.Minor, other.Minor},
+		{self.Subminor, other.Subminor},
+	} {
+		if pair[0] < pair[1] {
+			return -1
+		} else if pair[0] > pair[1] {
+			return 1
+		}
+	}
+	return 0
+}
+
+// Picks the tag whose version satisfies 'spec', according to 'strategy'.
+// Tags that don't carry a version are passed over.
+func SelectTag(spec *VersionSpec, strategy int, tags []string) (string, *Version, error) {
+	tagged := []string{}
+	versions := []*Version{}
+	for _, tag := range tags {
+		if ver, err := ParseVersion(tag); err == nil {
+			tagged = append(tagged, tag)
+			versions = append(versions, ver)
+		}
+	}
+	if idx := spec.Select(strategy, versions); idx >= 0 {
+		return tagged[idx], versions[idx], nil
+	}
+	return "", nil, fmt.Errorf("Cannot find a tag for version specification: %v.", spec)
+}
+
+// Picks the version that satisfies the spec, according to 'strategy'.
+// StrategyNewest picks the highest matching version, while StrategyMinimal
+// picks the lowest.  Returns the index of the version in 'versions', or -1
+// if nothing matches.
+func (self *VersionSpec) Select(strategy int, versions []*Version) int {
+	best := -1
+	for ii, ver := range versions {
+		if ver == nil || !self.IsSatisfiedBy(ver) {
+			continue
+		}
+		if best == -1 {
+			best = ii
+			continue
+		}
+		cmp := ver.Compare(versions[best])
+		if (strategy == StrategyMinimal && cmp < 0) ||
+			(strategy != StrategyMinimal && cmp > 0) {
+			best = ii
+		}
+	}
+	return best
+}
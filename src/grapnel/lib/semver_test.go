@@ -179,3 +179,101 @@ func TestVersionSatisfaction(t *testing.T) {
 		}
 	}
 }
+
+func TestVersionSpecSelect(t *testing.T) {
+	log.SetGlobalLogLevel(log.DEBUG)
+
+	versions := []*Version{}
+	for _, item := range []string{"1.2", "0.9", "1.0.1", "1.10", "2.0"} {
+		ver, err := ParseVersion(item)
+		if err != nil {
+			t.Errorf("Error parsing version: '%v': %v", item, err)
+		}
+		versions = append(versions, ver)
+	}
+
+	for _, item := range []struct {
+		Vspec    string
+		Strategy int
+		Result   int
+	}{
+		{">=1.0", StrategyNewest, 4},
+		{">=1.0", StrategyMinimal, 2},
+		{"1.*", StrategyNewest, 3},
+		{"1.*", StrategyMinimal, 2},
+		{"<1", StrategyMinimal, 1},
+		{">2", StrategyNewest, -1},
+	} {
+		vs, err := ParseVersionSpec(item.Vspec)
+		if err != nil {
+			t.Errorf("Error parsing version spec: '%v': %v", item.Vspec, err)
+		}
+		if idx := vs.Select(item.Strategy, versions); idx != item.Result {
+			t.Errorf("'%v' with strategy %v selected %v, expected %v",
+				item.Vspec, item.Strategy, idx, item.Result)
+		}
+	}
+}
+
+func TestVersionSpecIntersect(t *testing.T) {
+	versions := []*Version{}
+	for _, item := range []string{"1.0", "1.2", "1.3", "2.0", "2.4"} {
+		ver, _ := ParseVersion(item)
+		versions = append(versions, ver)
+	}
+
+	for _, item := range []struct {
+		VspecA   string
+		VspecB   string
+		Strategy int
+		Result   int
+	}{
+		{">=1.0", ">=1.2", StrategyMinimal, 1},
+		{">=1.0", ">=1.2", StrategyNewest, 4},
+		{"1.*", ">=1.2", StrategyNewest, 2},
+		{"<1.2", ">=1.2", StrategyMinimal, -2},
+	} {
+		vsA, _ := ParseVersionSpec(item.VspecA)
+		vsB, _ := ParseVersionSpec(item.VspecB)
+		vs := vsA.Intersect(vsB)
+		if vs == nil {
+			if item.Result != -2 {
+				t.Errorf("'%v' and '%v' should intersect", item.VspecA, item.VspecB)
+			}
+			continue
+		} else if item.Result == -2 {
+			t.Errorf("'%v' and '%v' should not intersect: %v", item.VspecA, item.VspecB, vs)
+			continue
+		}
+		if idx := vs.Select(item.Strategy, versions); idx != item.Result {
+			t.Errorf("'%v' with strategy %v selected %v, expected %v", vs, item.Strategy, idx, item.Result)
+		}
+	}
+}
+
+func TestSelectTag(t *testing.T) {
+	tags := []string{"v1.2", "latest", "v0.9", "release-1.0.1", "v2.0"}
+	for _, item := range []struct {
+		Vspec    string
+		Strategy int
+		Tag      string
+	}{
+		{">=1.0", StrategyNewest, "v2.0"},
+		{">=1.0", StrategyMinimal, "release-1.0.1"},
+		{"<1", StrategyNewest, "v0.9"},
+		{">2", StrategyNewest, ""},
+	} {
+		vs, _ := ParseVersionSpec(item.Vspec)
+		tag, ver, err := SelectTag(vs, item.Strategy, tags)
+		if item.Tag == "" {
+			if err == nil {
+				t.Errorf("'%v' selected '%v' from %v", item.Vspec, tag, tags)
+			}
+		} else if err != nil {
+			t.Errorf("Error selecting a tag for '%v': %v", item.Vspec, err)
+		} else if tag != item.Tag || !vs.IsSatisfiedBy(ver) {
+			t.Errorf("'%v' with strategy %v selected '%v' (%v), expected '%v'",
+				item.Vspec, item.Strategy, tag, ver, item.Tag)
+		}
+	}
+}
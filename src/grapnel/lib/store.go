@@ -0,0 +1,208 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	log "grapnel/log"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Holds one read-only copy of each library tree, keyed by its TreeHash, so
+// that installs can hardlink to it rather than copy.
+type Store struct {
+	Root string
+}
+
+func NewStore(root string) *Store {
+	return &Store{Root: root}
+}
+
+// Lists the files and symlinks in the tree at 'root', by slash-separated
+// path.  Each is described by its kind and a digest: 'file <sha256>',
+// 'exec <sha256>' for executables, or 'link <sha256 of the target>'.
+// Directories only count through what they hold.
+func TreeManifest(root string) (map[string]string, error) {
+	manifest := map[string]string{}
+	err := filepath.Walk(root, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		relativePath, _ := filepath.Rel(root, name)
+		kind := "file"
+		digest := ""
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			kind = "link"
+			target, err := os.Readlink(name)
+			if err != nil {
+				return err
+			}
+			sum := sha256.Sum256([]byte(target))
+			digest = hex.EncodeToString(sum[:])
+		case info.Mode().IsRegular():
+			if info.Mode()&0111 != 0 {
+				kind = "exec"
+			}
+			if digest, err = fileSha256(name); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("Cannot hash non-regular file: '%s'", relativePath)
+		}
+		manifest[filepath.ToSlash(relativePath)] = kind + " " + digest
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// Hashes a manifest, in path order.
+func ManifestHash(manifest map[string]string) string {
+	paths := []string{}
+	for name := range manifest {
+		paths = append(paths, name)
+	}
+	sort.Strings(paths)
+	hash := sha256.New()
+	for _, name := range paths {
+		fmt.Fprintf(hash, "%s %s\n", manifest[name], name)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Hashes the file tree at 'root'; see TreeManifest for what counts.
+func TreeHash(root string) (string, error) {
+	manifest, err := TreeManifest(root)
+	if err != nil {
+		return "", err
+	}
+	return ManifestHash(manifest), nil
+}
+
+// Compares two manifests, and returns the sorted paths that differ in
+// content or kind, that only 'expected' has, and that only 'actual' has.
+func DiffManifests(expected, actual map[string]string) (modified, missing, extra []string) {
+	for name, entry := range expected {
+		if actualEntry, ok := actual[name]; !ok {
+			missing = append(missing, name)
+		} else if actualEntry != entry {
+			modified = append(modified, name)
+		}
+	}
+	for name := range actual {
+		if _, ok := expected[name]; !ok {
+			extra = append(extra, name)
+		}
+	}
+	sort.Strings(modified)
+	sort.Strings(missing)
+	sort.Strings(extra)
+	return modified, missing, extra
+}
+
+// Removes a stored tree, by moving it aside first so that it disappears all
+// at once.
+func (self *Store) remove(dest string) error {
+	aside, err := ioutil.TempDir(filepath.Dir(dest), ".remove.")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(aside)
+	if err := os.Rename(dest, filepath.Join(aside, filepath.Base(dest))); err != nil && Exists(dest) {
+		return err
+	}
+	return nil
+}
+
+// Returns where the tree with 'hash' is kept.
+func (self *Store) Path(hash string) string {
+	return filepath.Join(self.Root, hash[:2], hash)
+}
+
+// Adds the file tree at 'src' to the store, unless it is there already, and
+// returns its hash.  Stored files are made read-only, as every install that
+// links to them shares them.  A stored tree that no longer matches its hash
+// is replaced.
+func (self *Store) Add(src string) (string, error) {
+	hash, err := TreeHash(src)
+	if err != nil {
+		return "", err
+	}
+	dest := self.Path(hash)
+	if Exists(dest) {
+		if stored, err := TreeHash(dest); err == nil && stored == hash {
+			log.Debug("Already in store: %s", hash)
+			return hash, nil
+		}
+		log.Warn("Replacing corrupt store entry: %s", dest)
+		if err := self.remove(dest); err != nil {
+			return "", fmt.Errorf("Cannot remove corrupt store entry: %v", err)
+		}
+	}
+
+	// build the copy to the side, so that it appears all at once
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return "", fmt.Errorf("Cannot create store directory: %v", err)
+	}
+	tempDir, err := ioutil.TempDir(filepath.Dir(dest), "."+hash+".")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tempDir)
+	if err := CopyFileTree(tempDir, src); err != nil {
+		return "", err
+	}
+	err = filepath.Walk(tempDir, func(name string, info os.FileInfo, err error) error {
+		if err != nil || info.Mode()&os.ModeSymlink != 0 {
+			return err
+		}
+		if info.IsDir() {
+			return os.Chmod(name, 0755)
+		}
+		// keep the source's executable bits, which count toward the hash
+		mode := os.FileMode(0444)
+		if srcInfo, err := os.Stat(filepath.Join(src, strings.TrimPrefix(name, tempDir))); err == nil {
+			mode |= srcInfo.Mode() & 0111
+		}
+		return os.Chmod(name, mode)
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := os.Rename(tempDir, dest); err != nil && !Exists(dest) {
+		return "", fmt.Errorf("Cannot add tree to store: %v", err)
+	}
+	return hash, nil
+}
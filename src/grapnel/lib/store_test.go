@@ -0,0 +1,324 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"bytes"
+	toml "github.com/pelletier/go-toml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Writes 'files' under a new temporary directory; names ending in '*' are
+// made executable.
+func buildTestTree(t *testing.T, files map[string]string) string {
+	root, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	for name, content := range files {
+		mode := os.FileMode(0644)
+		if name[len(name)-1] == '*' {
+			name = name[:len(name)-1]
+			mode = 0755
+		}
+		filename := filepath.Join(root, name)
+		os.MkdirAll(filepath.Dir(filename), 0755)
+		if err := ioutil.WriteFile(filename, []byte(content), mode); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	return root
+}
+
+func TestTreeHash(t *testing.T) {
+	base := map[string]string{"README": "readme", "bar/bar.go": "package bar"}
+	hashTree := func(files map[string]string) string {
+		root := buildTestTree(t, files)
+		defer os.RemoveAll(root)
+		hash, err := TreeHash(root)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		return hash
+	}
+	expected := hashTree(base)
+	if hash := hashTree(base); hash != expected {
+		t.Errorf("Same tree hashed differently: '%v' and '%v'", hash, expected)
+	}
+	for _, files := range []map[string]string{
+		{"README": "readme!", "bar/bar.go": "package bar"},
+		{"README": "readme", "baz/bar.go": "package bar"},
+		{"README*": "readme", "bar/bar.go": "package bar"},
+		{"README": "readme", "bar/bar.go": "package bar", "extra": ""},
+	} {
+		if hash := hashTree(files); hash == expected {
+			t.Errorf("Different tree hashed the same: %v", files)
+		}
+	}
+}
+
+func TestStoreInstall(t *testing.T) {
+	src := buildTestTree(t, map[string]string{
+		"README":     "readme",
+		"bar/bar.go": "package bar",
+		"run.sh*":    "#!/bin/sh",
+	})
+	defer os.RemoveAll(src)
+	storeRoot, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(storeRoot)
+	store := NewStore(storeRoot)
+
+	dep, _ := NewDependency("example.com/foo", "", "")
+	lib := NewLibrary(dep)
+	lib.TempDir = src
+
+	// two installs share the stored copy
+	installRoots := []string{}
+	for ii := 0; ii < 2; ii++ {
+		installRoot, err := ioutil.TempDir("", "")
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		defer os.RemoveAll(installRoot)
+		if err := lib.Install(installRoot, store, nil); err != nil {
+			t.Fatalf("%v", err)
+		}
+		installRoots = append(installRoots, installRoot)
+	}
+	expected, _ := TreeHash(src)
+	if lib.TreeHash != expected {
+		t.Errorf("Bad tree hash: '%v'. Expected: '%v'", lib.TreeHash, expected)
+	}
+	if hash, err := TreeHash(store.Path(expected)); err != nil || hash != expected {
+		t.Errorf("Stored tree hashes to '%v' (%v), expected '%v'", hash, err, expected)
+	}
+	for _, name := range []string{"README", "bar/bar.go", "run.sh"} {
+		stored, err := os.Stat(filepath.Join(store.Path(expected), name))
+		if err != nil {
+			t.Errorf("%v", err)
+			continue
+		}
+		if stored.Mode()&0222 != 0 {
+			t.Errorf("Stored file '%v' is writable: %v", name, stored.Mode())
+		}
+		for _, installRoot := range installRoots {
+			installed, err := os.Stat(filepath.Join(installRoot, "example.com/foo", name))
+			if err != nil || !os.SameFile(stored, installed) {
+				t.Errorf("'%v' in '%v' is not linked to the store (%v)", name, installRoot, err)
+			}
+		}
+	}
+
+	// copying a new tree over a linked install leaves the store alone
+	ioutil.WriteFile(filepath.Join(src, "README"), []byte("changed"), 0644)
+	lib.TreeHash = ""
+	if err := lib.Install(installRoots[0], nil, nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if hash, _ := TreeHash(store.Path(expected)); hash != expected {
+		t.Errorf("Copying an install changed the store")
+	}
+}
+
+func TestInstallReplacesOldVersion(t *testing.T) {
+	v1 := buildTestTree(t, map[string]string{
+		"README":     "v1",
+		"bar/bar.go": "package bar",
+		"run.sh*":    "#!/bin/sh",
+	})
+	defer os.RemoveAll(v1)
+	v2 := buildTestTree(t, map[string]string{
+		"README":     "v2",
+		"bar/bar.go": "package bar",
+	})
+	defer os.RemoveAll(v2)
+	sub := buildTestTree(t, map[string]string{"sub.go": "package sub"})
+	defer os.RemoveAll(sub)
+	expected, _ := TreeHash(v2)
+
+	storeRoot, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(storeRoot)
+
+	for _, store := range []*Store{nil, NewStore(storeRoot)} {
+		installRoot, err := ioutil.TempDir("", "")
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		defer os.RemoveAll(installRoot)
+
+		// v1, with a library installed inside of it
+		dep, _ := NewDependency("example.com/foo", "", "")
+		lib := NewLibrary(dep)
+		lib.TempDir = v1
+		subDep, _ := NewDependency("example.com/foo/sub", "", "")
+		subLib := NewLibrary(subDep)
+		subLib.TempDir = sub
+		for _, item := range []*Library{lib, subLib} {
+			if err := item.Install(installRoot, store, nil); err != nil {
+				t.Fatalf("%v", err)
+			}
+		}
+
+		lib.TempDir = v2
+		lib.TreeHash = ""
+		if err := lib.Install(installRoot, store, []string{"example.com/foo/sub"}); err != nil {
+			t.Fatalf("%v", err)
+		}
+		importPath := filepath.Join(installRoot, "example.com/foo")
+		manifest, err := TreeManifest(importPath)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if _, ok := manifest["sub/sub.go"]; !ok {
+			t.Errorf("Nested library was not kept: %v", manifest)
+		}
+		delete(manifest, "sub/sub.go")
+		if hash := ManifestHash(manifest); hash != expected {
+			t.Errorf("Installed tree hashes to '%v', expected '%v': %v", hash, expected, manifest)
+		}
+		if infos, _ := ioutil.ReadDir(filepath.Dir(importPath)); len(infos) != 1 {
+			t.Errorf("Install left %v entries next to the library", len(infos))
+		}
+		if info, err := os.Stat(importPath); err != nil || info.Mode().Perm() != 0755 {
+			t.Errorf("Bad mode for installed library: %v", err)
+		}
+	}
+}
+
+func TestStoreReplacesCorruptEntry(t *testing.T) {
+	src := buildTestTree(t, map[string]string{"README": "readme"})
+	defer os.RemoveAll(src)
+	storeRoot, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(storeRoot)
+	store := NewStore(storeRoot)
+
+	hash, err := store.Add(src)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// tamper with the stored copy
+	stored := filepath.Join(store.Path(hash), "README")
+	os.Chmod(stored, 0644)
+	ioutil.WriteFile(stored, []byte("tampered"), 0644)
+	ioutil.WriteFile(filepath.Join(store.Path(hash), "extra.go"), []byte("package evil"), 0444)
+
+	if again, err := store.Add(src); err != nil || again != hash {
+		t.Errorf("Error adding to store again: '%v' %v", again, err)
+	}
+	if actual, _ := TreeHash(store.Path(hash)); actual != hash {
+		t.Errorf("Corrupt store entry was kept: '%v', expected '%v'", actual, hash)
+	}
+	if content, _ := ioutil.ReadFile(stored); string(content) != "readme" {
+		t.Errorf("Bad content after replacing store entry: '%s'", content)
+	}
+}
+
+func TestDiffManifests(t *testing.T) {
+	expected := map[string]string{"a": "file 1", "b": "file 2", "c": "file 3", "d": "file 4"}
+	actual := map[string]string{"a": "file 1", "b": "file 20", "d": "exec 4", "e": "file 5"}
+	modified, missing, extra := DiffManifests(expected, actual)
+	for _, item := range []struct {
+		Label    string
+		Result   []string
+		Expected []string
+	}{
+		{"modified", modified, []string{"b", "d"}},
+		{"missing", missing, []string{"c"}},
+		{"extra", extra, []string{"e"}},
+	} {
+		if strings.Join(item.Result, ",") != strings.Join(item.Expected, ",") {
+			t.Errorf("Bad %v files: %v. Expected: %v", item.Label, item.Result, item.Expected)
+		}
+	}
+}
+
+func TestInstallTreeHash(t *testing.T) {
+	src := buildTestTree(t, map[string]string{"README": "readme", "run.sh*": "#!/bin/sh"})
+	defer os.RemoveAll(src)
+	expected, _ := TreeHash(src)
+
+	for _, item := range []struct {
+		TreeHash string
+		Ok       bool
+	}{
+		{"", true},
+		{expected, true},
+		{strings.Repeat("0", 64), false},
+	} {
+		installRoot, err := ioutil.TempDir("", "")
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		defer os.RemoveAll(installRoot)
+		dep, _ := NewDependency("example.com/foo", "", "")
+		dep.TreeHash = item.TreeHash
+		lib := NewLibrary(dep)
+		lib.TempDir = src
+		lib.Version = NewVersion(-1, -1, -1)
+		err = lib.Install(installRoot, nil, nil)
+		if item.Ok && err != nil {
+			t.Errorf("%v", err)
+		} else if !item.Ok {
+			if err == nil {
+				t.Errorf("Install with tree hash '%v' succeeded", item.TreeHash)
+			} else if Exists(filepath.Join(installRoot, "example.com/foo/README")) {
+				t.Errorf("Install with a bad tree hash left files behind")
+			}
+			continue
+		}
+		if lib.TreeHash != expected {
+			t.Errorf("Bad tree hash: '%v'. Expected: '%v'", lib.TreeHash, expected)
+		}
+
+		// copies keep their modes, so the installed tree hashes the same
+		if hash, err := TreeHash(filepath.Join(installRoot, "example.com/foo")); err != nil || hash != expected {
+			t.Errorf("Installed tree hashes to '%v' (%v), expected '%v'", hash, err, expected)
+		}
+
+		// the hash makes it through the lockfile
+		buffer := &bytes.Buffer{}
+		lib.ToToml(buffer)
+		tree, err := toml.Load(buffer.String())
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		loaded, err := NewDependencyFromToml(tree.Get("dependencies").([]*toml.TomlTree)[0])
+		if err != nil || loaded.TreeHash != expected {
+			t.Errorf("Bad tree hash from lockfile: '%v' (%v)", loaded.TreeHash, err)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"fmt"
+	log "grapnel/log"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+var SvnRewriteRules = RewriteRuleArray{
+	// rewrite rules for misc subversion resolvers
+	TypeResolverRule("scheme", `^svn(\+ssh)?$`, `svn`),
+	TypeResolverRule("host", `^svn\.`, `svn`),
+}
+
+type SvnSCM struct{}
+
+var svnLastChangedRev = regexp.MustCompile(`(?m)^Last Changed Rev:\s*(\d+)\s*$`)
+var svnRevision = regexp.MustCompile(`^\d+$`)
+
+// Maps a branch onto a path within the repository.  The default is 'trunk';
+// bare names are shorthand for 'branches/<name>'.
+func svnBranchPath(branch string) string {
+	if branch == "" || branch == "trunk" {
+		return "trunk"
+	} else if strings.HasPrefix(branch, "branches/") || strings.HasPrefix(branch, "tags/") {
+		return branch
+	}
+	return "branches/" + branch
+}
+
+func (self *SvnSCM) Resolve(dep *Dependency) (*Library, error) {
+	lib := NewLibrary(dep)
+
+	if lib.Url == nil {
+		return nil, fmt.Errorf("Cannot download svn dependency without a url: '%s'", lib.Import)
+	}
+	lib.Branch = svnBranchPath(lib.Branch)
+	repoUrl := strings.TrimSuffix(lib.Url.String(), "/")
+
+	// a tag is either a pinned revision number, or a name under 'tags/'
+	pinned := lib.Tag != ""
+	revision := "HEAD"
+	if svnRevision.MatchString(lib.Tag) {
+		revision = lib.Tag
+	} else if lib.Tag != "" {
+		lib.Branch = "tags/" + lib.Tag
+	}
+
+	log.Info("Fetching Svn Dependency: '%s'", lib.Import)
+
+	// create a dedicated directory and a context for commands
+	tempRoot, err := ioutil.TempDir("", "")
+	if err != nil {
+		return nil, err
+	}
+	lib.TempDir = tempRoot
+	cmd := NewRunContext(tempRoot)
+
+	// pick a tag by version, unless the dependency names one
+	if lib.VersionSpec.IsUnversioned() {
+		lib.Version = NewVersion(-1, -1, -1)
+	} else if pinned {
+		// a tag given up front still has to satisfy the version spec
+		tag := strings.TrimPrefix(lib.Branch, "tags/")
+		if ver, err := ParseVersion(tag); err == nil && tag != lib.Branch && lib.VersionSpec.IsSatisfiedBy(ver) {
+			lib.Version = ver
+		} else {
+			return nil, fmt.Errorf("Tag '%s' does not satisfy version specification: %v.", lib.Tag, lib.VersionSpec)
+		}
+	} else {
+		if err := cmd.Run("svn", "list", "--non-interactive", repoUrl+"/tags"); err != nil {
+			return nil, fmt.Errorf("Failed to acquire tag list for depenency")
+		}
+		tags := []string{}
+		for _, line := range strings.Split(cmd.CombinedOutput, "\n") {
+			tags = append(tags, strings.TrimSuffix(strings.TrimSpace(line), "/"))
+		}
+		tag, version, err := SelectTag(lib.VersionSpec, lib.Strategy, tags)
+		if err != nil {
+			return nil, err
+		}
+		lib.Branch = "tags/" + tag
+		lib.Version = version
+	}
+
+	// pin the Tag to the last revision that changed the path
+	pathUrl := repoUrl + "/" + lib.Branch + "@" + revision
+	if err := cmd.Run("svn", "info", "--non-interactive", pathUrl); err != nil {
+		return nil, fmt.Errorf("Cannot locate dependency: '%s'", pathUrl)
+	}
+	if matches := svnLastChangedRev.FindStringSubmatch(cmd.CombinedOutput); matches == nil {
+		return nil, fmt.Errorf("Failed to identify revision for: '%s'", pathUrl)
+	} else {
+		lib.Tag = matches[1]
+	}
+
+	// export the tree at the pinned revision; this leaves no '.svn' behind
+	pathUrl = repoUrl + "/" + lib.Branch + "@" + lib.Tag
+	log.Info("Fetching remote data for %s", lib.Import)
+	if err := cmd.Run("svn", "export", "--non-interactive", "--force", pathUrl, tempRoot); err != nil {
+		return nil, fmt.Errorf("Cannot download dependency: '%s'", pathUrl)
+	}
+
+	if lib.Version.Major == -1 {
+		log.Warn("Resolved: %v (unversioned)", lib.Import)
+	} else {
+		log.Info("Resolved: %s %v", lib.Import, lib.Version)
+	}
+	return lib, nil
+}
+
+func (self *SvnSCM) ToDSD(lib *Library) string {
+	pathUrl := strings.TrimSuffix(lib.Url.String(), "/") + "/" + lib.Branch + "@" + lib.Tag
+	return dsdLines(
+		dsdCommand("check_equal", "revision", lib.Tag,
+			`$(svn info --non-interactive `+shellQuote(pathUrl)+` | sed -n 's/^Last Changed Rev: //p')`),
+		dsdCommand("svn", "export", "--quiet", "--non-interactive", "--force", "-r", lib.Tag, pathUrl, "$dir"))
+}
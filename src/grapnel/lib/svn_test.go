@@ -0,0 +1,110 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	. "grapnel/testing"
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+)
+
+func TestSvnBranchPath(t *testing.T) {
+	for branch, expected := range map[string]string{
+		"":             "trunk",
+		"trunk":        "trunk",
+		"stable":       "branches/stable",
+		"branches/1.x": "branches/1.x",
+		"tags/v1.0":    "tags/v1.0",
+	} {
+		if result := svnBranchPath(branch); result != expected {
+			t.Errorf("Branch '%v' mapped to '%v', expected '%v'", branch, result, expected)
+		}
+	}
+}
+
+func TestSvnSource(t *testing.T) {
+	if _, err := exec.LookPath("svnadmin"); err != nil {
+		t.Skip("svn is not installed")
+	}
+	InitTestLogging()
+
+	// construct a repo
+	basePath := BuildTestSvnRepo("svnrepo")
+	defer os.RemoveAll(basePath)
+	repoUrl := "file://" + path.Join(basePath, "svnrepo")
+
+	libsrc := &SvnSCM{}
+
+	// versioned checkouts come from 'tags/'
+	dep, err := NewDependency("foo/bar/baz", repoUrl, "1.0")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	lib, err := libsrc.Resolve(dep)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(lib.TempDir)
+	if lib.Branch != "tags/v1.0" {
+		t.Errorf("Expected branch 'tags/v1.0'; got '%v' instead", lib.Branch)
+	}
+	if !Exists(path.Join(lib.TempDir, "README")) || Exists(path.Join(lib.TempDir, "foo.txt")) {
+		t.Errorf("Expected v1.0 to be exported")
+	}
+
+	// a pinned tag and revision are kept, rather than the newest matching tag
+	pinned, _ := NewDependency("foo/bar/baz", repoUrl, "1.*")
+	pinned.Branch = lib.Branch
+	pinned.Tag = lib.Tag
+	if relib, err := libsrc.Resolve(pinned); err != nil {
+		t.Errorf("Error resolving pinned revision: %v", err)
+	} else {
+		defer os.RemoveAll(relib.TempDir)
+		if relib.Branch != lib.Branch || relib.Tag != lib.Tag {
+			t.Errorf("Pinned %v@%v resolved to %v@%v", lib.Branch, lib.Tag, relib.Branch, relib.Tag)
+		}
+	}
+
+	// ... and have to satisfy the version spec
+	pinned.VersionSpec, _ = ParseVersionSpec("1.1")
+	if relib, err := libsrc.Resolve(pinned); err == nil {
+		defer os.RemoveAll(relib.TempDir)
+		t.Errorf("Pinned tag resolved okay against the wrong version")
+	}
+
+	// unversioned checkouts pin the revision of trunk
+	dep, err = NewDependency("foo/bar/baz", repoUrl, "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	lib, err = libsrc.Resolve(dep)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(lib.TempDir)
+	if lib.Branch != "trunk" || lib.Tag != "4" {
+		t.Errorf("Expected trunk at revision 4; got '%v' at '%v' instead", lib.Branch, lib.Tag)
+	}
+}
@@ -60,13 +60,18 @@ func CopySymlink(src, dst string) (err error) {
 // CopyFileContents copies the contents of the file named src to the file named
 // by dst. The file will be created if it does not already exist. If the
 // destination file exists, all it's contents will be replaced by the contents
-// of the source file.
+// of the source file.  The copy gets the permissions of the source, so that
+// executables stay executable.
 func CopyFileContents(src, dst string) (err error) {
 	in, err := os.Open(src)
 	if err != nil {
 		return
 	}
 	defer in.Close()
+	srcInfo, err := in.Stat()
+	if err != nil {
+		return
+	}
 	out, err := os.Create(dst)
 	if err != nil {
 		return
@@ -80,6 +85,9 @@ func CopyFileContents(src, dst string) (err error) {
 	if _, err = io.Copy(out, in); err != nil {
 		return
 	}
+	if err = out.Chmod(srcInfo.Mode().Perm()); err != nil {
+		return
+	}
 	err = out.Sync()
 	return
 }
@@ -100,28 +108,22 @@ func Exists(name string) bool {
 // most of the heavy lifting. Expands '~/' in a path to the current user's
 // home directory
 func AbsolutePath(path string) (string, error) {
-	// promote path to absolute path
-	result, err := filepath.Abs(path)
-	if err != nil {
-		return "", err
-	}
-
-	// Check in case of paths like "/something/~/something/"
-	if result[:2] == "~/" {
+	// expand the home directory before the path is made absolute
+	if strings.HasPrefix(path, "~/") {
 		// attempt to get user information
 		usr, err := user.Current()
 		if err != nil {
 			return "", err
 		}
-		dir := usr.HomeDir
-		result = strings.Replace(result, "~/", dir, 1)
+		path = filepath.Join(usr.HomeDir, path[2:])
 	}
-	return result, nil
+	return filepath.Abs(path)
 }
 
 type RunContext struct {
 	WorkingDirectory string
 	CombinedOutput   string
+	Env              []string // added to the environment of each command; never logged
 }
 
 func NewRunContext(workingDirectory string) *RunContext {
@@ -133,6 +135,9 @@ func NewRunContext(workingDirectory string) *RunContext {
 func (self *RunContext) Run(cmd string, args ...string) error {
 	cmdObj := exec.Command(cmd, args...)
 	cmdObj.Dir = self.WorkingDirectory
+	if len(self.Env) > 0 {
+		cmdObj.Env = append(os.Environ(), self.Env...)
+	}
 	log.Debug("%v %v", cmd, args)
 	out, err := cmdObj.CombinedOutput()
 	self.CombinedOutput = string(out)
@@ -149,6 +154,9 @@ func (self *RunContext) Run(cmd string, args ...string) error {
 func (self *RunContext) Start(cmd string, args ...string) (*exec.Cmd, error) {
 	cmdObj := exec.Command(cmd, args...)
 	cmdObj.Dir = self.WorkingDirectory
+	if len(self.Env) > 0 {
+		cmdObj.Env = append(os.Environ(), self.Env...)
+	}
 	err := cmdObj.Start()
 	return cmdObj, err
 }
@@ -181,7 +189,50 @@ func CopyFileTree(dest string, src string) error {
 				if err := CopySymlink(path, destPath); err != nil {
 					return fmt.Errorf("Could not copy symlink '%s' to '%s'", path, destPath)
 				}
-			} else if err := CopyFileContents(path, destPath); err != nil {
+			} else {
+				// replace rather than write through any link into the store
+				os.Remove(destPath)
+				if err := CopyFileContents(path, destPath); err != nil {
+					return fmt.Errorf("Could not copy file '%s' to '%s'", path, destPath)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Populates dest with hardlinks to the file tree at src.  Files that can't be
+// linked, such as on another filesystem, are copied instead.
+func LinkFileTree(dest string, src string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			log.Info("%s", err.Error())
+			return fmt.Errorf("Error while walking file tree")
+		}
+		relativePath, _ := filepath.Rel(src, path)
+		destPath := filepath.Join(dest, relativePath)
+		if info.IsDir() {
+			// create target directory if it's not already there
+			if !Exists(destPath) {
+				if err := os.MkdirAll(destPath, 0755); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		log.Debug("Linking: %s", destPath)
+		if (info.Mode() & os.ModeSymlink) == os.ModeSymlink {
+			os.Remove(destPath)
+			if err := CopySymlink(path, destPath); err != nil {
+				return fmt.Errorf("Could not copy symlink '%s' to '%s'", path, destPath)
+			}
+		} else if err := LinkFile(path, destPath); err != nil {
+			os.Remove(destPath)
+			if err := LinkFile(path, destPath); err == nil {
+				return nil
+			}
+			log.Debug("Cannot link '%s'; copying instead", destPath)
+			if err := CopyFileContents(path, destPath); err != nil {
 				return fmt.Errorf("Could not copy file '%s' to '%s'", path, destPath)
 			}
 		}
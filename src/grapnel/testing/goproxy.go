@@ -0,0 +1,79 @@
+package testing
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"archive/zip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+// Builds a file tree that can stand in for a GOPROXY server, with a 'file://'
+// url.  Each version of 'modulePath' gets a README and a doc.go.
+func BuildTestGoProxy(modulePath string, versions ...string) string {
+	var err error
+	var basePath string
+	if basePath, err = ioutil.TempDir("", ""); err != nil {
+		panic(err)
+	}
+	versionPath := path.Join(basePath, modulePath, "@v")
+	if err = os.MkdirAll(versionPath, 0755); err != nil {
+		panic(err)
+	}
+
+	list := ""
+	for _, version := range versions {
+		list += version + "\n"
+		info := fmt.Sprintf(`{"Version":"%s","Time":"2015-01-24T00:00:00Z"}`, version)
+		if err = ioutil.WriteFile(path.Join(versionPath, version+".info"), []byte(info), 0644); err != nil {
+			panic(err)
+		}
+
+		file, err := os.Create(path.Join(versionPath, version+".zip"))
+		if err != nil {
+			panic(err)
+		}
+		writer := zip.NewWriter(file)
+		prefix := modulePath + "@" + version + "/"
+		for name, content := range map[string]string{
+			"README": version,
+			"doc.go": "package " + path.Base(modulePath) + "\n",
+		} {
+			entry, err := writer.Create(prefix + name)
+			if err != nil {
+				panic(err)
+			}
+			entry.Write([]byte(content))
+		}
+		if err = writer.Close(); err != nil {
+			panic(err)
+		}
+		file.Close()
+	}
+	if err = ioutil.WriteFile(path.Join(versionPath, "list"), []byte(list), 0644); err != nil {
+		panic(err)
+	}
+	return "file://" + basePath
+}
@@ -0,0 +1,63 @@
+package testing
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	util "grapnel/util"
+	"io/ioutil"
+)
+
+// Builds a GnuPG home directory holding a single, passphrase-less signing
+// key for 'email'.
+func BuildTestKeyring(email string) string {
+	var err error
+	var basePath string
+	if basePath, err = ioutil.TempDir("", ""); err != nil {
+		panic(err)
+	}
+	cmd := util.NewRunContext(basePath)
+	cmd.MustRun("gpg", "--homedir", basePath, "--batch", "--passphrase", "",
+		"--quick-gen-key", email, "ed25519", "sign", "never")
+	return basePath
+}
+
+// Stops the agent that gpg leaves running for a keyring.
+func StopTestKeyring(basePath string) {
+	cmd := util.NewRunContext(basePath)
+	cmd.Run("gpgconf", "--homedir", basePath, "--kill", "gpg-agent")
+}
+
+// Builds a GnuPG home directory holding just the public key for 'email' from
+// the keyring at 'source', which is known but not trusted.
+func ImportTestKey(source string, email string) string {
+	var err error
+	var basePath string
+	if basePath, err = ioutil.TempDir("", ""); err != nil {
+		panic(err)
+	}
+	keyFile := basePath + "/key.asc"
+	cmd := util.NewRunContext(basePath)
+	cmd.MustRun("gpg", "--homedir", source, "--batch", "--output", keyFile, "--export", email)
+	cmd.MustRun("gpg", "--homedir", basePath, "--batch", "--import", keyFile)
+	return basePath
+}
@@ -0,0 +1,113 @@
+package testing
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"fmt"
+	log "grapnel/log"
+	util "grapnel/util"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"time"
+)
+
+var hgServe *exec.Cmd
+
+func StartHgServe(repoPath string) error {
+	if hgServe != nil {
+		return nil
+	}
+
+	log.Info("Serving: %v", repoPath)
+	cmd := exec.Command("hg", "serve",
+		"--repository", repoPath,
+		"--port", "9998",
+		"--address", "localhost")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// start the server
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	hgServe = cmd
+
+	// wait for it to halt asynchronously
+	go func() {
+		log.Info("hg serve stopped: %v", cmd.Wait())
+		hgServe = nil
+	}()
+
+	// wait for the server to accept connections
+	for attempt := 0; ; attempt++ {
+		conn, err := net.Dial("tcp", "localhost:9998")
+		if err == nil {
+			conn.Close()
+			break
+		} else if attempt >= 50 {
+			StopHgServe()
+			return fmt.Errorf("hg serve failed to start: %v", err)
+		}
+		<-time.After(100 * time.Millisecond)
+	}
+
+	return nil
+}
+
+func StopHgServe() {
+	if hgServe == nil {
+		return
+	}
+	hgServe.Process.Signal(os.Interrupt)
+}
+
+func BuildTestHgRepo(repoName string) string {
+	var err error
+	var basePath string
+	if basePath, err = ioutil.TempDir("", ""); err != nil {
+		panic(err)
+	}
+	repoPath := path.Join(basePath, repoName)
+	if err = os.Mkdir(repoPath, 0755); err != nil {
+		panic(err)
+	}
+
+	cmd := util.NewRunContext(repoPath)
+	for _, data := range [][]string{
+		{"hg", "init"},
+		{"touch", "README"},
+		{"hg", "add", "README"},
+		{"hg", "commit", "-u", "Your Name <you@example.com>", "-m", "first commit"},
+		{"hg", "tag", "-u", "Your Name <you@example.com>", "v1.0"},
+		{"touch", "foo.txt"},
+		{"hg", "add", "foo.txt"},
+		{"hg", "commit", "-u", "Your Name <you@example.com>", "-m", "second commit"},
+		{"hg", "tag", "-u", "Your Name <you@example.com>", "v1.1"},
+	} {
+		cmd.MustRun(data[0], data[1:]...)
+	}
+	return basePath
+}
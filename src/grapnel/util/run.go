@@ -0,0 +1,61 @@
+package util
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	log "grapnel/log"
+	"os"
+	"os/exec"
+)
+
+// Runs commands for the test fixtures in 'grapnel/testing', which can't use
+// the RunContext in 'grapnel/lib' without an import cycle in its tests.
+type RunContext struct {
+	WorkingDirectory string
+	CombinedOutput   string
+	Env              []string // added to the environment of each command
+}
+
+func NewRunContext(workingDirectory string) *RunContext {
+	return &RunContext{
+		WorkingDirectory: workingDirectory,
+	}
+}
+
+func (self *RunContext) Run(cmd string, args ...string) error {
+	cmdObj := exec.Command(cmd, args...)
+	cmdObj.Dir = self.WorkingDirectory
+	if len(self.Env) > 0 {
+		cmdObj.Env = append(os.Environ(), self.Env...)
+	}
+	log.Debug("%v %v", cmd, args)
+	out, err := cmdObj.CombinedOutput()
+	self.CombinedOutput = string(out)
+	return err
+}
+
+func (self *RunContext) MustRun(cmd string, args ...string) {
+	if err := self.Run(cmd, args...); err != nil {
+		log.Fatal(self.CombinedOutput)
+	}
+}